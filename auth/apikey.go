@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+)
+
+const (
+	// APIKeyHeader is the request header that carries an API key
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyPrefix = "sk_"
+	// minBootstrapSecret is the shortest secret, after the prefix, accepted for a bootstrap key; generated keys have 43
+	minBootstrapSecret = 32
+	// lastUsedResolution limits how often the last-used timestamp of a busy key is written to the store
+	lastUsedResolution = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("auth: invalid API key")
+	ErrAPIKeyNotFound = errors.New("auth: API key not found")
+)
+
+// An APIKey is the stored record of an API key. Only a peppered hash of the key itself is kept.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Owner     string    `json:"owner"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	LastUsed  time.Time `json:"last_used"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// Valid reports whether the key may be used at now
+func (k APIKey) Valid(now time.Time) bool {
+	return !k.Revoked && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// A KeyStore persists API keys
+type KeyStore interface {
+	Create(key APIKey) error
+	List() ([]APIKey, error)
+	// FindByHash returns ErrAPIKeyNotFound when no key has the hash
+	FindByHash(hash string) (APIKey, error)
+	// Revoke returns ErrAPIKeyNotFound when there is no key with the ID
+	Revoke(id string) error
+	SetLastUsed(id string, t time.Time) error
+}
+
+// APIKeys issues API keys and authenticates requests that carry them. Keys are hashed with HMAC-SHA256 keyed by a
+// server-side pepper, so a leaked store cannot be used to authenticate.
+type APIKeys struct {
+	store  KeyStore
+	pepper []byte
+	usage  kitmetrics.Counter
+}
+
+// NewAPIKeys returns a new APIKeys. Every successful authentication is counted by usage, labeled by key ID and owner.
+func NewAPIKeys(store KeyStore, pepper []byte, usage kitmetrics.Counter) *APIKeys {
+	return &APIKeys{store: store, pepper: pepper, usage: usage}
+}
+
+// Create issues a new key for owner. The returned secret is shown to the caller once and never stored.
+func (a *APIKeys) Create(owner string, scopes []string, ttl time.Duration) (string, APIKey, error) {
+	id, err := randomString(8)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	plaintext := apiKeyPrefix + secret
+
+	now := time.Now().UTC()
+	key := APIKey{
+		ID:        id,
+		Hash:      a.hash(plaintext),
+		Owner:     owner,
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+	if err := a.store.Create(key); err != nil {
+		return "", APIKey{}, err
+	}
+	return plaintext, key, nil
+}
+
+// Bootstrap stores plaintext as a key for owner unless it is in the store already, revoked or not, and reports
+// whether it did. It lets operators get the first admin key into an empty store from a secret; once it has been used
+// to create other keys it can be revoked and stays so across restarts.
+func (a *APIKeys) Bootstrap(plaintext, owner string, scopes []string) (bool, error) {
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) || len(plaintext) < len(apiKeyPrefix)+minBootstrapSecret {
+		return false, fmt.Errorf("auth: a bootstrap API key must be %q followed by at least %d characters", apiKeyPrefix, minBootstrapSecret)
+	}
+	hash := a.hash(plaintext)
+	_, err := a.store.FindByHash(hash)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrAPIKeyNotFound) {
+		return false, err
+	}
+
+	id, err := randomString(8)
+	if err != nil {
+		return false, err
+	}
+	key := APIKey{
+		ID:        id,
+		Hash:      hash,
+		Owner:     owner,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := a.store.Create(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// List returns every key, including revoked and expired ones
+func (a *APIKeys) List() ([]APIKey, error) {
+	return a.store.List()
+}
+
+// Revoke revokes the key with the given ID
+func (a *APIKeys) Revoke(id string) error {
+	return a.store.Revoke(id)
+}
+
+// Authenticate implements Authenticator. The key's owner becomes the subject and its ID the client ID of the claims.
+func (a *APIKeys) Authenticate(r *http.Request) (Claims, error) {
+	plaintext := r.Header.Get(APIKeyHeader)
+	if plaintext == "" {
+		return Claims{}, ErrNoCredentials
+	}
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) {
+		return Claims{}, ErrInvalidAPIKey
+	}
+
+	key, err := a.store.FindByHash(a.hash(plaintext))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return Claims{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return Claims{}, err
+	}
+	now := time.Now()
+	if !key.Valid(now) {
+		return Claims{}, ErrInvalidAPIKey
+	}
+
+	if now.Sub(key.LastUsed) > lastUsedResolution {
+		// Failing to record the last use must not fail the request
+		_ = a.store.SetLastUsed(key.ID, now.UTC())
+	}
+	a.usage.With("key_id", key.ID, "owner", key.Owner).Add(1)
+
+	return Claims{
+		Subject:   key.Owner,
+		ClientID:  key.ID,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+	}, nil
+}
+
+func (a *APIKeys) hash(plaintext string) string {
+	mac := hmac.New(sha256.New, a.pepper)
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A FileKeyStore is a KeyStore that keeps API keys in a JSON file. The whole file is held in memory and rewritten
+// atomically on every change, which is fine for the handful of keys batch jobs need.
+type FileKeyStore struct {
+	path string
+
+	mu   sync.Mutex
+	keys []APIKey
+}
+
+// NewFileKeyStore returns a FileKeyStore backed by the file at path, loading any keys it already holds
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.keys); err != nil {
+		return nil, fmt.Errorf("auth: reading key store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Create implements KeyStore
+func (s *FileKeyStore) Create(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	return s.save()
+}
+
+// List implements KeyStore
+func (s *FileKeyStore) List() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]APIKey(nil), s.keys...), nil
+}
+
+// FindByHash implements KeyStore
+func (s *FileKeyStore) FindByHash(hash string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+// Revoke implements KeyStore
+func (s *FileKeyStore) Revoke(id string) error {
+	return s.update(id, func(key *APIKey) {
+		key.Revoked = true
+	})
+}
+
+// SetLastUsed implements KeyStore
+func (s *FileKeyStore) SetLastUsed(id string, t time.Time) error {
+	return s.update(id, func(key *APIKey) {
+		key.LastUsed = t
+	})
+}
+
+func (s *FileKeyStore) update(id string, f func(key *APIKey)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.keys {
+		if s.keys[i].ID == id {
+			f(&s.keys[i])
+			return s.save()
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// save writes the keys to a temporary file and renames it over the store, so readers never see a partial file; it
+// must be called with s.mu held
+func (s *FileKeyStore) save() error {
+	b, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval keeps a flood of tokens with unknown key IDs from hammering the JWKS endpoint
+const minRefreshInterval = 10 * time.Second
+
+// A JWKS is a KeySource backed by a JSON Web Key Set fetched over HTTP. The keys are cached for the refresh interval
+// and refetched early when a token names a key ID that is not in the cache, which picks up rotated keys. If a
+// refresh fails the cached keys are kept.
+//
+// Keys are fetched in the background, one fetch at a time. Tokens signed with a cached key are verified with it
+// while a refresh runs; only tokens naming a key that is not cached wait for the fetch.
+type JWKS struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]jwk
+	fetchedAt time.Time
+	fetchErr  error
+	// fetching is closed when the running fetch completes; nil when none runs
+	fetching chan struct{}
+}
+
+type jwk struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// NewJWKS returns a new JWKS that fetches keys from url
+func NewJWKS(url string, client *http.Client, refresh time.Duration) *JWKS {
+	return &JWKS{url: url, client: client, refresh: refresh}
+}
+
+// Key implements KeySource
+func (s *JWKS) Key(kid, alg string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	k, ok := s.keys[kid]
+	stale := time.Since(s.fetchedAt) > s.refresh
+	rotated := !ok && time.Since(s.fetchedAt) > minRefreshInterval
+	if (stale || rotated) && s.fetching == nil {
+		s.startFetch()
+	}
+	done := s.fetching
+	s.mu.Unlock()
+
+	if !ok && done != nil {
+		<-done
+		s.mu.Lock()
+		k, ok = s.keys[kid]
+		if s.keys == nil && s.fetchErr != nil {
+			err := s.fetchErr
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.mu.Unlock()
+	}
+	if !ok || (k.alg != "" && k.alg != alg) {
+		return nil, ErrUnknownKey
+	}
+	return k.key, nil
+}
+
+// startFetch fetches the keys in the background; it must be called with s.mu held
+func (s *JWKS) startFetch() {
+	// Failed fetches count too, so that an unreachable endpoint is not retried on every request
+	s.fetchedAt = time.Now()
+	done := make(chan struct{})
+	s.fetching = done
+	go func() {
+		keys, err := s.fetch(context.Background())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err == nil {
+			s.keys = keys
+		}
+		s.fetchErr = err
+		s.fetching = nil
+		close(done)
+	}()
+}
+
+func (s *JWKS) fetch(ctx context.Context) (map[string]jwk, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("auth: fetching JWKS: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: fetching JWKS: unexpected status %s", res.Status)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("auth: decoding JWKS: %w", err)
+	}
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, raw := range set.Keys {
+		kid, k, err := parseJWK(raw)
+		if err != nil {
+			// Skip keys we do not understand, e.g. encryption keys
+			continue
+		}
+		keys[kid] = k
+	}
+	return keys, nil
+}
+
+func parseJWK(raw json.RawMessage) (string, jwk, error) {
+	var k struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return "", jwk{}, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return "", jwk{}, fmt.Errorf("auth: key %q is not a signing key", k.Kid)
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		if !e.IsInt64() {
+			return "", jwk{}, fmt.Errorf("auth: key %q has an invalid exponent", k.Kid)
+		}
+		return k.Kid, jwk{alg: k.Alg, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return "", jwk{}, fmt.Errorf("auth: key %q uses unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return "", jwk{}, fmt.Errorf("auth: key %q is not on its curve", k.Kid)
+		}
+		return k.Kid, jwk{alg: k.Alg, key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	default:
+		return "", jwk{}, fmt.Errorf("auth: key %q has unsupported type %q", k.Kid, k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// age makes the cached keys of s look as if they were fetched d ago
+func age(s *JWKS, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetchedAt = s.fetchedAt.Add(-d)
+}
+
+func TestJWKSPicksUpRotatedKeys(t *testing.T) {
+	server := newJWKSServer(t, rsaJWK("rsa-1", rsaKey))
+	jwks := NewJWKS(server.URL, server.Client(), time.Hour)
+	v := newVerifier(t, jwks)
+	oldToken := sign(t, RS256, "rsa-1", rsaKey, validClaims())
+	newToken := sign(t, ES256, "ec-1", ecKey, validClaims())
+
+	if _, err := v.Verify(oldToken); err != nil {
+		t.Fatalf("Verify with the old key: %v", err)
+	}
+	server.setKeys(ecJWK("ec-1", ecKey))
+
+	// A key ID that is not cached only triggers a refetch once minRefreshInterval has passed
+	if _, err := v.Verify(newToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify with the new key right after a fetch: error = %v, want %v", err, ErrUnknownKey)
+	}
+	if n := server.fetchCount(); n != 1 {
+		t.Errorf("fetches = %d, want 1", n)
+	}
+
+	age(jwks, minRefreshInterval)
+	if _, err := v.Verify(newToken); err != nil {
+		t.Errorf("Verify with the new key: %v", err)
+	}
+	if n := server.fetchCount(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+	if _, err := v.Verify(oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify with the retired key: error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestJWKSServesCachedKeysWhileRefreshing(t *testing.T) {
+	server := newJWKSServer(t, rsaJWK("rsa-1", rsaKey))
+	jwks := NewJWKS(server.URL, server.Client(), time.Minute)
+	v := newVerifier(t, jwks)
+	token := sign(t, RS256, "rsa-1", rsaKey, validClaims())
+
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	block := make(chan struct{})
+	server.mu.Lock()
+	server.block = block
+	server.mu.Unlock()
+	defer close(block)
+	age(jwks, time.Hour)
+
+	// The stale keys start a refresh that hangs, but must not hold up tokens signed with a cached key
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(token)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("Verify during a refresh: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Verify waited for the refresh")
+	}
+}
+
+func TestJWKSKeepsKeysWhenRefreshFails(t *testing.T) {
+	server := newJWKSServer(t, rsaJWK("rsa-1", rsaKey))
+	jwks := NewJWKS(server.URL, server.Client(), time.Minute)
+	v := newVerifier(t, jwks)
+	token := sign(t, RS256, "rsa-1", rsaKey, validClaims())
+
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	server.mu.Lock()
+	server.status = http.StatusInternalServerError
+	server.mu.Unlock()
+
+	// Naming an unknown key waits for the failed refresh, after which the cached keys must still be there
+	age(jwks, time.Hour)
+	if _, err := v.Verify(sign(t, RS256, "rsa-2", rsaKey, validClaims())); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify with an unknown key: error = %v, want %v", err, ErrUnknownKey)
+	}
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("Verify after a failed refresh: %v", err)
+	}
+}
+
+func TestJWKSReportsFirstFetchFailure(t *testing.T) {
+	server := newJWKSServer(t)
+	server.status = http.StatusServiceUnavailable
+	v := newVerifier(t, NewJWKS(server.URL, server.Client(), time.Minute))
+
+	_, err := v.Verify(sign(t, RS256, "rsa-1", rsaKey, validClaims()))
+	if err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify error = %v, want the fetch error", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Package auth authenticates callers of the service. Bearer tokens are JWTs whose signatures are verified against a
+// JWKS, and whose verified claims are made available to handlers through the request context.
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The signing algorithms accepted by Verifier
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	HS256 = "HS256"
+)
+
+var (
+	ErrMalformedToken   = errors.New("auth: malformed token")
+	ErrUnsupportedAlg   = errors.New("auth: unsupported signing algorithm")
+	ErrInvalidSignature = errors.New("auth: invalid signature")
+	ErrUnknownKey       = errors.New("auth: unknown signing key")
+	ErrExpired          = errors.New("auth: token is expired")
+	ErrNoExpiry         = errors.New("auth: token has no expiry")
+	ErrNotYetValid      = errors.New("auth: token is not valid yet")
+	ErrInvalidIssuer    = errors.New("auth: invalid issuer")
+	ErrInvalidAudience  = errors.New("auth: invalid audience")
+)
+
+// Claims are the verified claims of a token
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	// ClientID is the client_id claim, or azp when there is none
+	ClientID string
+	// Scopes come from the space-delimited scope claim or the scp array
+	Scopes []string
+	// Raw holds every claim of the token
+	Raw map[string]any
+}
+
+// HasScopes reports whether the claims grant every one of scopes
+func (c Claims) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// A KeySource returns the public key with the given key ID for alg
+type KeySource interface {
+	Key(kid, alg string) (crypto.PublicKey, error)
+}
+
+// VerifierConfig configures a Verifier. An empty Issuer or Audience is not checked.
+type VerifierConfig struct {
+	Keys KeySource
+	// HMACSecret enables HS256 tokens signed with the shared secret
+	HMACSecret []byte
+	Issuer     string
+	Audience   []string
+	// Leeway is the allowed clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+// A Verifier verifies the signature and registered claims of JWTs
+type Verifier struct {
+	config VerifierConfig
+	now    func() time.Time
+}
+
+// NewVerifier returns a new Verifier
+func NewVerifier(config VerifierConfig) *Verifier {
+	return &Verifier{config: config, now: time.Now}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the token and returns its claims
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformedToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformedToken
+	}
+	if err := v.verifySignature(h, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return Claims{}, err
+	}
+
+	var raw map[string]any
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return Claims{}, err
+	}
+	claims := newClaims(raw)
+	if err := v.validate(claims); err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(h header, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	switch h.Alg {
+	case HS256:
+		if len(v.config.HMACSecret) == 0 {
+			return ErrUnsupportedAlg
+		}
+		mac := hmac.New(sha256.New, v.config.HMACSecret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case RS256:
+		key, err := v.publicKey(h)
+		if err != nil {
+			return err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnknownKey
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case ES256:
+		key, err := v.publicKey(h)
+		if err != nil {
+			return err
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedAlg
+	}
+}
+
+func (v *Verifier) publicKey(h header) (crypto.PublicKey, error) {
+	if v.config.Keys == nil {
+		return nil, ErrUnsupportedAlg
+	}
+	return v.config.Keys.Key(h.Kid, h.Alg)
+}
+
+func (v *Verifier) validate(c Claims) error {
+	now := v.now()
+	leeway := v.config.Leeway
+	// A token that never expires could not be withdrawn once leaked
+	if c.ExpiresAt.IsZero() {
+		return ErrNoExpiry
+	}
+	if now.After(c.ExpiresAt.Add(leeway)) {
+		return ErrExpired
+	}
+	if !c.NotBefore.IsZero() && now.Add(leeway).Before(c.NotBefore) {
+		return ErrNotYetValid
+	}
+	if v.config.Issuer != "" && c.Issuer != v.config.Issuer {
+		return ErrInvalidIssuer
+	}
+	if len(v.config.Audience) > 0 && !slices.ContainsFunc(c.Audience, func(aud string) bool {
+		return slices.Contains(v.config.Audience, aud)
+	}) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	return nil
+}
+
+func newClaims(raw map[string]any) Claims {
+	c := Claims{
+		Issuer:    stringClaim(raw, "iss"),
+		Subject:   stringClaim(raw, "sub"),
+		Audience:  stringsClaim(raw, "aud"),
+		ExpiresAt: timeClaim(raw, "exp"),
+		NotBefore: timeClaim(raw, "nbf"),
+		IssuedAt:  timeClaim(raw, "iat"),
+		ClientID:  stringClaim(raw, "client_id"),
+		Raw:       raw,
+	}
+	if c.ClientID == "" {
+		c.ClientID = stringClaim(raw, "azp")
+	}
+	if scope := stringClaim(raw, "scope"); scope != "" {
+		c.Scopes = strings.Fields(scope)
+	} else {
+		c.Scopes = stringsClaim(raw, "scp")
+	}
+	return c
+}
+
+func stringClaim(raw map[string]any, name string) string {
+	s, _ := raw[name].(string)
+	return s
+}
+
+// stringsClaim returns a claim that may be a single string or an array of strings
+func stringsClaim(raw map[string]any, name string) []string {
+	switch v := raw[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func timeClaim(raw map[string]any, name string) time.Time {
+	n, ok := raw[name].(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0)
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hmacKey   = []byte("test-secret")
+)
+
+// now is the time tokens are verified at
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// sign returns a token with the given header and claims, signed with key as alg says. An alg the function does not
+// know gets an empty signature.
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case RS256:
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims returns claims that pass every check of newVerifier
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":       "https://issuer.example.com",
+		"sub":       "alice",
+		"aud":       "dictionary",
+		"exp":       now.Add(time.Hour).Unix(),
+		"client_id": "web",
+		"scope":     "lookup admin",
+	}
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA",
+		"kid": kid,
+		"alg": RS256,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]any {
+	return map[string]any{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// jwksServer is a stand-in for an identity provider's JWKS endpoint whose keys can be changed, e.g. to rotate them
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []map[string]any
+	status  int
+	fetches int
+	// block, if set, holds up responses until it is closed
+	block chan struct{}
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]any) *jwksServer {
+	s := &jwksServer{keys: keys, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.fetches++
+		block := s.block
+		s.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func newVerifier(t *testing.T, keys KeySource) *Verifier {
+	t.Helper()
+	v := NewVerifier(VerifierConfig{
+		Keys:       keys,
+		HMACSecret: hmacKey,
+		Issuer:     "https://issuer.example.com",
+		Audience:   []string{"dictionary", "thesaurus"},
+		Leeway:     time.Minute,
+	})
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func TestVerifySignatures(t *testing.T) {
+	server := newJWKSServer(t, rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	v := newVerifier(t, NewJWKS(server.URL, server.Client(), time.Hour))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"RS256", sign(t, RS256, "rsa-1", rsaKey, validClaims())},
+		{"ES256", sign(t, ES256, "ec-1", ecKey, validClaims())},
+		{"HS256", sign(t, HS256, "", hmacKey, validClaims())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Verify(tt.token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if claims.Subject != "alice" || claims.ClientID != "web" || !claims.HasScopes("lookup", "admin") {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsBadSignatures(t *testing.T) {
+	server := newJWKSServer(t, rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	v := newVerifier(t, NewJWKS(server.URL, server.Client(), time.Hour))
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The signature of one token with the claims of another
+	genuine := strings.Split(sign(t, RS256, "rsa-1", rsaKey, validClaims()), ".")
+	claims := validClaims()
+	claims["sub"] = "mallory"
+	tampered := strings.Split(sign(t, RS256, "rsa-1", rsaKey, claims), ".")
+	forged := genuine[0] + "." + tampered[1] + "." + genuine[2]
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"tampered claims", forged, ErrInvalidSignature},
+		{"signed by another RSA key", sign(t, RS256, "rsa-1", otherRSAKey, validClaims()), ErrInvalidSignature},
+		{"signed by another EC key", sign(t, ES256, "ec-1", otherECKey, validClaims()), ErrInvalidSignature},
+		{"signed with another HMAC secret", sign(t, HS256, "", []byte("other"), validClaims()), ErrInvalidSignature},
+		{"unsigned", sign(t, "none", "", nil, validClaims()), ErrUnsupportedAlg},
+		{"unknown key ID", sign(t, RS256, "rsa-2", rsaKey, validClaims()), ErrUnknownKey},
+		{"malformed", "not.a-token", ErrMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsAlgorithmKeyMismatch(t *testing.T) {
+	// ec-1 does not declare its alg, so only its type keeps it from being used for RS256
+	server := newJWKSServer(t, rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	jwks := NewJWKS(server.URL, server.Client(), time.Hour)
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		token    string
+		want     error
+	}{
+		{"ES256 with a key declared RS256", newVerifier(t, jwks), sign(t, ES256, "rsa-1", ecKey, validClaims()), ErrUnknownKey},
+		{"RS256 with an EC key", newVerifier(t, jwks), sign(t, RS256, "ec-1", rsaKey, validClaims()), ErrUnknownKey},
+		{"HS256 without a secret", func() *Verifier {
+			v := NewVerifier(VerifierConfig{Keys: jwks})
+			v.now = func() time.Time { return now }
+			return v
+		}(), sign(t, HS256, "", hmacKey, validClaims()), ErrUnsupportedAlg},
+		{"RS256 without a JWKS", func() *Verifier {
+			v := NewVerifier(VerifierConfig{HMACSecret: hmacKey})
+			v.now = func() time.Time { return now }
+			return v
+		}(), sign(t, RS256, "rsa-1", rsaKey, validClaims()), ErrUnsupportedAlg},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.verifier.Verify(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksClaims(t *testing.T) {
+	v := newVerifier(t, nil)
+
+	tests := []struct {
+		name   string
+		modify func(claims map[string]any)
+		want   error
+	}{
+		{"valid", func(map[string]any) {}, nil},
+		{"expired", func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, ErrExpired},
+		{"expired within leeway", func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() }, nil},
+		{"no exp", func(c map[string]any) { delete(c, "exp") }, ErrNoExpiry},
+		{"not yet valid", func(c map[string]any) { c["nbf"] = now.Add(2 * time.Minute).Unix() }, ErrNotYetValid},
+		{"not yet valid within leeway", func(c map[string]any) { c["nbf"] = now.Add(30 * time.Second).Unix() }, nil},
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }, ErrInvalidIssuer},
+		{"no issuer", func(c map[string]any) { delete(c, "iss") }, ErrInvalidIssuer},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "billing" }, ErrInvalidAudience},
+		{"one of several audiences", func(c map[string]any) { c["aud"] = []string{"billing", "thesaurus"} }, nil},
+		{"no audience", func(c map[string]any) { delete(c, "aud") }, ErrInvalidAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+			if _, err := v.Verify(sign(t, HS256, "", hmacKey, claims)); !errors.Is(err, tt.want) {
+				t.Errorf("Verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/StephenGriese/stdlibapp/problem"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no credentials it understands
+var ErrNoCredentials = errors.New("auth: no credentials")
+
+// An Authenticator verifies the credentials of a request and returns the claims they grant
+type Authenticator interface {
+	Authenticate(r *http.Request) (Claims, error)
+}
+
+// BearerAuthenticator authenticates requests with a JWT in the Authorization header
+type BearerAuthenticator struct {
+	Verifier *Verifier
+}
+
+// Authenticate implements Authenticator
+func (a BearerAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return Claims{}, ErrNoCredentials
+	}
+	return a.Verifier.Verify(token)
+}
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+)
+
+// WithClaims returns a copy of ctx that carries the verified claims
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the verified claims stored by the middleware, if any
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(Claims)
+	return claims, ok
+}
+
+// NewHandler returns middleware that requires credentials accepted by one of authenticators, granting every one of
+// scopes. The first authenticator that finds credentials in the request decides. The verified claims are put into
+// the request context. Requests without valid credentials get 401 Unauthorized and requests lacking a scope get 403
+// Forbidden, each with a WWW-Authenticate header (RFC 6750) and a problem body.
+func NewHandler(authenticators []Authenticator, scopes []string, handler http.Handler) http.Handler {
+	return authHandler{authenticators: authenticators, scopes: scopes, handler: handler}
+}
+
+type authHandler struct {
+	authenticators []Authenticator
+	scopes         []string
+	handler        http.Handler
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.authenticate(r)
+	if errors.Is(err, ErrNoCredentials) {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		problem.Error(w, r, http.StatusUnauthorized, "credentials are required")
+		return
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, description(err)))
+		problem.Error(w, r, http.StatusUnauthorized, description(err))
+		return
+	}
+	if !claims.HasScopes(h.scopes...) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(h.scopes, " ")))
+		problem.Error(w, r, http.StatusForbidden, fmt.Sprintf("the credentials do not grant the scopes %s", strings.Join(h.scopes, " ")))
+		return
+	}
+
+	h.handler.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+}
+
+func (h authHandler) authenticate(r *http.Request) (Claims, error) {
+	for _, a := range h.authenticators {
+		claims, err := a.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return claims, err
+		}
+	}
+	return Claims{}, ErrNoCredentials
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// description returns a message for err that is safe to show to the caller
+func description(err error) string {
+	for _, known := range []error{ErrMalformedToken, ErrUnsupportedAlg, ErrInvalidSignature, ErrUnknownKey, ErrExpired,
+		ErrNoExpiry, ErrNotYetValid, ErrInvalidIssuer, ErrInvalidAudience, ErrInvalidAPIKey} {
+		if errors.Is(err, known) {
+			return strings.TrimPrefix(known.Error(), "auth: ")
+		}
+	}
+	return "credentials could not be verified"
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StephenGriese/stdlibapp/problem"
+)
+
+func TestHandlerRejectsWithProblems(t *testing.T) {
+	authenticators := []Authenticator{BearerAuthenticator{Verifier: newVerifier(t, nil)}}
+	handler := NewHandler(authenticators, []string{"write"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{"no credentials", "", http.StatusUnauthorized, "Bearer"},
+		{"invalid token", "Bearer not-a-token", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"missing scope", "Bearer " + sign(t, HS256, "", hmacKey, validClaims()), http.StatusForbidden, `Bearer error="insufficient_scope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/lookup/cat", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, problem.ContentType)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, tt.challenge) {
+				t.Errorf("WWW-Authenticate = %q, want it to start with %q", challenge, tt.challenge)
+			}
+		})
+	}
+}
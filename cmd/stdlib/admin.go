@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/auth"
+	"github.com/StephenGriese/stdlibapp/dictionary"
+	"github.com/StephenGriese/stdlibapp/featureflag"
+)
+
+// apiKeyView is how an API key is shown by the admin endpoints; the hash never leaves the store
+type apiKeyView struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key,omitempty"`
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	Revoked   bool       `json:"revoked"`
+}
+
+func newAPIKeyView(key auth.APIKey) apiKeyView {
+	return apiKeyView{
+		ID:        key.ID,
+		Owner:     key.Owner,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+		ExpiresAt: nonZeroTime(key.ExpiresAt),
+		LastUsed:  nonZeroTime(key.LastUsed),
+		Revoked:   key.Revoked,
+	}
+}
+
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// handleCreateAPIKey serves POST /admin/apikeys. The response holds the key itself, which is never shown again.
+func handleCreateAPIKey(logger dictionary.Logger, apiKeys *auth.APIKeys) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Owner  string   `json:"owner"`
+			Scopes []string `json:"scopes"`
+			TTL    string   `json:"ttl"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if body.Owner == "" {
+			http.Error(w, "owner is required", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if body.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(body.TTL); err != nil || ttl < 0 {
+				http.Error(w, "ttl must be a positive duration", http.StatusBadRequest)
+				return
+			}
+		}
+
+		plaintext, key, err := apiKeys.Create(body.Owner, body.Scopes, ttl)
+		if err != nil {
+			logger.Info(r.Context(), "failed to create API key", "err", err)
+			http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+			return
+		}
+		logger.Info(r.Context(), "created API key", "id", key.ID, "owner", key.Owner)
+
+		view := newAPIKeyView(key)
+		view.Key = plaintext
+		writeJSON(w, http.StatusCreated, view)
+	})
+}
+
+// handleListAPIKeys serves GET /admin/apikeys
+func handleListAPIKeys(apiKeys *auth.APIKeys) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := apiKeys.List()
+		if err != nil {
+			http.Error(w, "Failed to list API keys", http.StatusInternalServerError)
+			return
+		}
+		views := make([]apiKeyView, 0, len(keys))
+		for _, key := range keys {
+			views = append(views, newAPIKeyView(key))
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+}
+
+// handleRevokeAPIKey serves DELETE /admin/apikeys/{id}
+func handleRevokeAPIKey(logger dictionary.Logger, apiKeys *auth.APIKeys) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		err := apiKeys.Revoke(id)
+		if errors.Is(err, auth.ErrAPIKeyNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Info(r.Context(), "failed to revoke API key", "id", id, "err", err)
+			http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+			return
+		}
+		logger.Info(r.Context(), "revoked API key", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// featureFlagView is how a feature flag is shown by the admin endpoints
+type featureFlagView struct {
+	Name       string   `json:"name"`
+	Percentage int      `json:"percentage"`
+	Allow      []string `json:"allow,omitempty"`
+	Deny       []string `json:"deny,omitempty"`
+	Override   *bool    `json:"override,omitempty"`
+}
+
+func newFeatureFlagView(state featureflag.State) featureFlagView {
+	return featureFlagView{
+		Name:       state.Name,
+		Percentage: state.Percentage,
+		Allow:      state.Allow,
+		Deny:       state.Deny,
+		Override:   state.Override,
+	}
+}
+
+// handleListFeatureFlags serves GET /admin/flags
+func handleListFeatureFlags(flags *featureflag.Set) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		states := flags.List()
+		views := make([]featureFlagView, 0, len(states))
+		for _, state := range states {
+			views = append(views, newFeatureFlagView(state))
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+}
+
+// handleOverrideFeatureFlag serves PUT /admin/flags/{name}/override, which turns a flag on or off for every client
+// of this instance until the override is cleared or the process restarts
+func handleOverrideFeatureFlag(logger dictionary.Logger, flags *featureflag.Set) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if body.Enabled == nil {
+			http.Error(w, "enabled is required", http.StatusBadRequest)
+			return
+		}
+		name := r.PathValue("name")
+		err := flags.Override(name, *body.Enabled)
+		if errors.Is(err, featureflag.ErrUnknownFlag) {
+			http.Error(w, "Feature flag not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Info(r.Context(), "failed to override feature flag", "flag", name, "err", err)
+			http.Error(w, "Failed to override feature flag", http.StatusInternalServerError)
+			return
+		}
+		logger.Info(r.Context(), "overrode feature flag", "flag", name, "enabled", *body.Enabled)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// handleClearFeatureFlagOverride serves DELETE /admin/flags/{name}/override
+func handleClearFeatureFlagOverride(logger dictionary.Logger, flags *featureflag.Set) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		err := flags.ClearOverride(name)
+		if errors.Is(err, featureflag.ErrUnknownFlag) {
+			http.Error(w, "Feature flag not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Info(r.Context(), "failed to clear feature flag override", "flag", name, "err", err)
+			http.Error(w, "Failed to clear feature flag override", http.StatusInternalServerError)
+			return
+		}
+		logger.Info(r.Context(), "cleared feature flag override", "flag", name)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/auth"
+	"github.com/StephenGriese/stdlibapp/compression"
+	"github.com/StephenGriese/stdlibapp/config"
+	"github.com/StephenGriese/stdlibapp/cors"
+	"github.com/StephenGriese/stdlibapp/dictionary"
+	"github.com/StephenGriese/stdlibapp/featureflag"
+	"github.com/StephenGriese/stdlibapp/httpcache"
+	"github.com/StephenGriese/stdlibapp/lifecycle"
+	"github.com/StephenGriese/stdlibapp/ratelimit"
+	"github.com/StephenGriese/stdlibapp/requestid"
+	"github.com/StephenGriese/stdlibapp/tlsconfig"
+)
+
+type Config struct {
+	AppName string
+	// Server is the public listener serving /lookup
+	Server ServerConfig
+	// AdminServer is the listener serving /metrics, pprof and the admin endpoints. When its Port is empty the public
+	// listener serves /metrics and the admin endpoints instead, and pprof is not served at all.
+	AdminServer ServerConfig
+	// LookupLimits bound each /lookup request
+	LookupLimits RouteLimits
+	// AdminLimits bound each admin API request; /metrics, the probes and pprof are not limited
+	AdminLimits   RouteLimits
+	DownstreamURL string
+	// DownstreamHealthURL is probed by the dictionary health check; empty disables the check
+	DownstreamHealthURL string
+	DownstreamTransport dictionary.TransportConfig
+	DownstreamRateLimit DownstreamRateLimitConfig
+	InboundRateLimit    InboundRateLimitConfig
+	Auth                AuthConfig
+	DownstreamAuth      dictionary.TokenSourceConfig
+	HeaderPolicy        dictionary.HeaderPolicy
+	TLS                 tlsconfig.Config
+	// TLSReloadInterval is how often the certificate files are checked for changes
+	TLSReloadInterval time.Duration
+	Lifecycle         lifecycle.Config
+	// LogLevel is debug, info, warn or error
+	LogLevel string
+	// ConfigReloadInterval is how often the config file is checked for changes
+	ConfigReloadInterval time.Duration
+	// LookupCache says how caches may reuse lookup responses
+	LookupCache httpcache.Config
+	Compression compression.Config
+	CORS        cors.Config
+	// FeatureFlags are defined as tables under feature_flags in the config file, one per flag
+	FeatureFlags []featureflag.Flag
+}
+
+// ServerConfig configures one HTTP listener
+type ServerConfig struct {
+	Host              string
+	Port              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// MaxHeaderBytes bounds the size of the request headers; zero means http.DefaultMaxHeaderBytes
+	MaxHeaderBytes int
+}
+
+// RouteLimits bound what a single request to a route may cost. Zero disables a limit.
+type RouteLimits struct {
+	MaxBodyBytes int64
+	// Timeout bounds the time the handler may take to respond
+	Timeout time.Duration
+}
+
+// Addr returns the address to listen on
+func (c ServerConfig) Addr() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+// AuthConfig configures authentication. Bearer tokens are accepted when JWKSURL or HMACSecret is set, and API keys
+// when APIKeyStore is set; authentication is disabled when neither is.
+type AuthConfig struct {
+	JWKSURL     string
+	JWKSRefresh time.Duration
+	HMACSecret  string
+	Issuer      string
+	Audience    []string
+	Leeway      time.Duration
+	// LookupScopes are the scopes a token must grant to call /lookup
+	LookupScopes []string
+	// APIKeyStore is the path of the file holding the API keys
+	APIKeyStore string
+	// APIKeyPepper is the server-side secret API keys are hashed with
+	APIKeyPepper string
+	// BootstrapAPIKey is added to the store with AdminScopes if the store does not hold it yet, so that the first
+	// keys can be created through the admin endpoints. Revoke it once they have been.
+	BootstrapAPIKey string
+	// AdminScopes are the scopes a token must grant to call the admin endpoints
+	AdminScopes []string
+}
+
+// InboundRateLimitConfig configures the per-client limit on requests to the service. A zero Limit disables it.
+type InboundRateLimitConfig struct {
+	Limit     int
+	Window    time.Duration
+	Burst     int
+	Algorithm string
+	// KeySources are tried in order to identify the client; see ratelimit.KeyFromSources
+	KeySources []string
+}
+
+// DownstreamRateLimitConfig holds the quotas of the downstream service. A zero limit is not enforced.
+type DownstreamRateLimitConfig struct {
+	PerSecond          int
+	Burst              int
+	PerDay             int
+	PerCallerPerSecond int
+	// MaxWait is the longest a lookup waits for the quota; zero waits until the lookup times out
+	MaxWait time.Duration
+}
+
+// createConfig reads every setting from l, then validates the result. All problems are reported together.
+func createConfig(l *config.Loader) (Config, error) {
+	server := ServerConfig{
+		Host: l.String("server.host", "BIND_HOST", "localhost"),
+		Port: l.String("server.port", "PORT", "8080"),
+		// Slow clients must not be able to hold connections open forever
+		ReadTimeout:       l.Duration("server.read_timeout", "READ_TIMEOUT", 30*time.Second),
+		ReadHeaderTimeout: l.Duration("server.read_header_timeout", "READ_HEADER_TIMEOUT", 5*time.Second),
+		// Longer than LOOKUP_TIMEOUT, so that timed out lookups still get their response
+		WriteTimeout:   l.Duration("server.write_timeout", "WRITE_TIMEOUT", time.Minute),
+		IdleTimeout:    l.Duration("server.idle_timeout", "IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes: l.Int("server.max_header_bytes", "MAX_HEADER_BYTES", 64<<10),
+	}
+	adminServer := ServerConfig{
+		Host:              l.String("admin_server.host", "ADMIN_BIND_HOST", "localhost"),
+		Port:              l.String("admin_server.port", "ADMIN_PORT", ""),
+		ReadTimeout:       l.Duration("admin_server.read_timeout", "ADMIN_READ_TIMEOUT", 10*time.Second),
+		ReadHeaderTimeout: l.Duration("admin_server.read_header_timeout", "ADMIN_READ_HEADER_TIMEOUT", 5*time.Second),
+		// Long enough for a 30 second CPU profile
+		WriteTimeout:   l.Duration("admin_server.write_timeout", "ADMIN_WRITE_TIMEOUT", time.Minute),
+		IdleTimeout:    l.Duration("admin_server.idle_timeout", "ADMIN_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes: l.Int("admin_server.max_header_bytes", "ADMIN_MAX_HEADER_BYTES", 64<<10),
+	}
+	lookupLimits := RouteLimits{
+		MaxBodyBytes: int64(l.Int("limits.lookup.max_body_bytes", "LOOKUP_MAX_BODY_BYTES", 1<<20)),
+		Timeout:      l.Duration("limits.lookup.timeout", "LOOKUP_TIMEOUT", 30*time.Second),
+	}
+	adminLimits := RouteLimits{
+		MaxBodyBytes: int64(l.Int("limits.admin.max_body_bytes", "ADMIN_MAX_BODY_BYTES", 64<<10)),
+		Timeout:      l.Duration("limits.admin.timeout", "ADMIN_TIMEOUT", 10*time.Second),
+	}
+	downstreamURL := l.String("downstream.url", "DOWNSTREAM_URL", "")
+	transport := dictionary.TransportConfig{
+		MaxIdleConns:          l.Int("downstream.transport.max_idle_conns", "DOWNSTREAM_MAX_IDLE_CONNS", 100),
+		MaxIdleConnsPerHost:   l.Int("downstream.transport.max_idle_conns_per_host", "DOWNSTREAM_MAX_IDLE_CONNS_PER_HOST", 10),
+		MaxConnsPerHost:       l.Int("downstream.transport.max_conns_per_host", "DOWNSTREAM_MAX_CONNS_PER_HOST", 0),
+		IdleConnTimeout:       l.Duration("downstream.transport.idle_conn_timeout", "DOWNSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second),
+		DialTimeout:           l.Duration("downstream.transport.dial_timeout", "DOWNSTREAM_DIAL_TIMEOUT", 5*time.Second),
+		KeepAlive:             l.Duration("downstream.transport.keep_alive", "DOWNSTREAM_KEEP_ALIVE", 30*time.Second),
+		DisableKeepAlives:     l.Bool("downstream.transport.disable_keep_alives", "DOWNSTREAM_DISABLE_KEEP_ALIVES", false),
+		TLSHandshakeTimeout:   l.Duration("downstream.transport.tls_handshake_timeout", "DOWNSTREAM_TLS_HANDSHAKE_TIMEOUT", 5*time.Second),
+		ResponseHeaderTimeout: l.Duration("downstream.transport.response_header_timeout", "DOWNSTREAM_RESPONSE_HEADER_TIMEOUT", 10*time.Second),
+		EnableHTTP2:           l.Bool("downstream.transport.http2", "DOWNSTREAM_HTTP2", true),
+	}
+	rateLimit := DownstreamRateLimitConfig{
+		PerSecond:          l.Int("downstream.rate_limit.per_second", "DOWNSTREAM_RATE_LIMIT_PER_SECOND", 0),
+		Burst:              l.Int("downstream.rate_limit.burst", "DOWNSTREAM_RATE_LIMIT_BURST", 0),
+		PerDay:             l.Int("downstream.rate_limit.per_day", "DOWNSTREAM_RATE_LIMIT_PER_DAY", 0),
+		PerCallerPerSecond: l.Int("downstream.rate_limit.per_caller_per_second", "DOWNSTREAM_RATE_LIMIT_PER_CALLER_PER_SECOND", 0),
+		MaxWait:            l.Duration("downstream.rate_limit.max_wait", "DOWNSTREAM_RATE_LIMIT_MAX_WAIT", 5*time.Second),
+	}
+	downstreamAuth := dictionary.TokenSourceConfig{
+		TokenURL:      l.String("downstream.auth.token_url", "DOWNSTREAM_TOKEN_URL", ""),
+		ClientID:      l.String("downstream.auth.client_id", "DOWNSTREAM_CLIENT_ID", ""),
+		ClientSecret:  l.Secret("downstream.auth.client_secret", "DOWNSTREAM_CLIENT_SECRET"),
+		RefreshBefore: l.Duration("downstream.auth.refresh_before", "DOWNSTREAM_TOKEN_REFRESH_BEFORE", time.Minute),
+	}
+	inboundRateLimit := InboundRateLimitConfig{
+		Limit:      l.Int("inbound_rate_limit.limit", "INBOUND_RATE_LIMIT", 0),
+		Window:     l.Duration("inbound_rate_limit.window", "INBOUND_RATE_LIMIT_WINDOW", time.Second),
+		Burst:      l.Int("inbound_rate_limit.burst", "INBOUND_RATE_LIMIT_BURST", 0),
+		Algorithm:  l.String("inbound_rate_limit.algorithm", "INBOUND_RATE_LIMIT_ALGORITHM", ratelimit.AlgorithmTokenBucket),
+		KeySources: l.List("inbound_rate_limit.key_sources", "INBOUND_RATE_LIMIT_KEY", []string{ratelimit.KeySourceJWT, ratelimit.KeySourceAPIKey, ratelimit.KeySourceIP}),
+	}
+	authConfig := AuthConfig{
+		JWKSURL:         l.String("auth.jwks_url", "AUTH_JWKS_URL", ""),
+		JWKSRefresh:     l.Duration("auth.jwks_refresh", "AUTH_JWKS_REFRESH", time.Hour),
+		HMACSecret:      l.Secret("auth.hmac_secret", "AUTH_HMAC_SECRET"),
+		Issuer:          l.String("auth.issuer", "AUTH_ISSUER", ""),
+		Audience:        l.List("auth.audience", "AUTH_AUDIENCE", nil),
+		Leeway:          l.Duration("auth.leeway", "AUTH_LEEWAY", 30*time.Second),
+		LookupScopes:    l.List("auth.lookup_scopes", "AUTH_LOOKUP_SCOPES", nil),
+		APIKeyStore:     l.String("auth.api_key_store", "AUTH_API_KEY_STORE", ""),
+		APIKeyPepper:    l.Secret("auth.api_key_pepper", "AUTH_API_KEY_PEPPER"),
+		BootstrapAPIKey: l.Secret("auth.bootstrap_api_key", "AUTH_BOOTSTRAP_API_KEY"),
+		AdminScopes:     l.List("auth.admin_scopes", "AUTH_ADMIN_SCOPES", []string{"admin"}),
+	}
+	headerPolicy := dictionary.HeaderPolicy{
+		Request: dictionary.HeaderRules{
+			Allow:  l.List("proxy.request_headers.allow", "PROXY_REQUEST_HEADERS_ALLOW", nil),
+			Deny:   l.List("proxy.request_headers.deny", "PROXY_REQUEST_HEADERS_DENY", dictionary.DefaultHeaderRequestDeny),
+			Rename: l.Map("proxy.request_headers.rename", "PROXY_REQUEST_HEADERS_RENAME"),
+			Set:    l.Map("proxy.request_headers.set", "PROXY_REQUEST_HEADERS_SET"),
+		},
+		Response: dictionary.HeaderRules{
+			Allow:  l.List("proxy.response_headers.allow", "PROXY_RESPONSE_HEADERS_ALLOW", nil),
+			Deny:   l.List("proxy.response_headers.deny", "PROXY_RESPONSE_HEADERS_DENY", dictionary.DefaultHeaderResponseDeny),
+			Rename: l.Map("proxy.response_headers.rename", "PROXY_RESPONSE_HEADERS_RENAME"),
+			Set:    l.Map("proxy.response_headers.set", "PROXY_RESPONSE_HEADERS_SET"),
+		},
+	}
+	tlsConfig := tlsconfig.Config{
+		CertFile:     l.String("tls.cert_file", "TLS_CERT_FILE", ""),
+		KeyFile:      l.String("tls.key_file", "TLS_KEY_FILE", ""),
+		MinVersion:   l.String("tls.min_version", "TLS_MIN_VERSION", "1.2"),
+		CipherSuites: l.List("tls.cipher_suites", "TLS_CIPHER_SUITES", nil),
+		ClientCAFile: l.String("tls.client_ca_file", "TLS_CLIENT_CA_FILE", ""),
+		ClientAuth:   l.String("tls.client_auth", "TLS_CLIENT_AUTH", tlsconfig.ClientAuthNone),
+	}
+	lifecycleConfig := lifecycle.Config{
+		PreStopDelay: l.Duration("shutdown.pre_stop_delay", "SHUTDOWN_PRE_STOP_DELAY", 5*time.Second),
+		DrainTimeout: l.Duration("shutdown.drain_timeout", "SHUTDOWN_DRAIN_TIMEOUT", 20*time.Second),
+		HookTimeout:  l.Duration("shutdown.hook_timeout", "SHUTDOWN_HOOK_TIMEOUT", 5*time.Second),
+	}
+	lookupCache := httpcache.Config{
+		CacheControl: l.String("lookup_cache.cache_control", "LOOKUP_CACHE_CONTROL", "public, max-age=3600"),
+		MaxETagSize:  l.Int("lookup_cache.max_etag_size", "LOOKUP_CACHE_MAX_ETAG_SIZE", 1<<20),
+		// Lookups may be authenticated with either
+		CredentialHeaders: []string{"Authorization", auth.APIKeyHeader},
+	}
+	if s := l.String("lookup_cache.last_modified", "LOOKUP_CACHE_LAST_MODIFIED", ""); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			l.Errorf("lookup_cache.last_modified (LOOKUP_CACHE_LAST_MODIFIED): %q is not an RFC 3339 time, e.g. 2024-01-02T15:04:05Z", s)
+		}
+		lookupCache.LastModified = t
+	}
+	compressionConfig := compression.Config{
+		Enabled:   l.Bool("compression.enabled", "COMPRESSION_ENABLED", true),
+		Encodings: l.List("compression.encodings", "COMPRESSION_ENCODINGS", []string{compression.EncodingZstd, compression.EncodingGzip, compression.EncodingDeflate}),
+		MinSize:   l.Int("compression.min_size", "COMPRESSION_MIN_SIZE", 1024),
+		ContentTypes: l.List("compression.content_types", "COMPRESSION_CONTENT_TYPES", []string{"text/*", "application/json",
+			"application/problem+json", "application/xml", "application/javascript", "application/openmetrics-text", "image/svg+xml"}),
+	}
+	corsConfig := cors.Config{
+		AllowedOrigins:   l.List("cors.allowed_origins", "CORS_ALLOWED_ORIGINS", nil),
+		AllowedMethods:   l.List("cors.allowed_methods", "CORS_ALLOWED_METHODS", []string{http.MethodGet, http.MethodHead}),
+		AllowedHeaders:   l.List("cors.allowed_headers", "CORS_ALLOWED_HEADERS", []string{"Authorization", "X-API-Key", "X-Client-Id", requestid.Header}),
+		ExposedHeaders:   l.List("cors.exposed_headers", "CORS_EXPOSED_HEADERS", []string{requestid.Header}),
+		AllowCredentials: l.Bool("cors.allow_credentials", "CORS_ALLOW_CREDENTIALS", false),
+		MaxAge:           l.Duration("cors.max_age", "CORS_MAX_AGE", 10*time.Minute),
+	}
+	var featureFlags []featureflag.Flag
+	for _, name := range l.Names("feature_flags") {
+		key := "feature_flags." + name
+		featureFlags = append(featureFlags, featureflag.Flag{
+			Name:       name,
+			Percentage: l.Int(key+".percentage", "", 0),
+			Allow:      l.List(key+".allow", "", nil),
+			Deny:       l.List(key+".deny", "", nil),
+		})
+	}
+
+	c := Config{
+		AppName:              l.String("app_name", "APP_NAME", "stdlibapp"),
+		Server:               server,
+		AdminServer:          adminServer,
+		LookupLimits:         lookupLimits,
+		AdminLimits:          adminLimits,
+		DownstreamURL:        downstreamURL,
+		DownstreamHealthURL:  l.String("downstream.health_url", "DOWNSTREAM_HEALTH_URL", downstreamURL),
+		DownstreamTransport:  transport,
+		DownstreamRateLimit:  rateLimit,
+		InboundRateLimit:     inboundRateLimit,
+		Auth:                 authConfig,
+		DownstreamAuth:       downstreamAuth,
+		HeaderPolicy:         headerPolicy,
+		TLS:                  tlsConfig,
+		TLSReloadInterval:    l.Duration("tls.reload_interval", "TLS_RELOAD_INTERVAL", time.Minute),
+		Lifecycle:            lifecycleConfig,
+		LogLevel:             l.String("log.level", "LOG_LEVEL", "info"),
+		ConfigReloadInterval: l.Duration("config_reload_interval", "CONFIG_RELOAD_INTERVAL", 10*time.Second),
+		LookupCache:          lookupCache,
+		Compression:          compressionConfig,
+		CORS:                 corsConfig,
+		FeatureFlags:         featureFlags,
+	}
+	if err := errors.Join(l.Err(), c.Validate()); err != nil {
+		return c, fmt.Errorf("invalid config: %w", err)
+	}
+	return c, nil
+}
+
+// Validate checks the settings that can be wrong even though they parse, and reports every problem found
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.AppName != "", "app_name: must not be empty")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log.level: must be debug, info, warn or error")
+	check(c.ConfigReloadInterval > 0, "config_reload_interval: must be positive")
+	errs = append(errs, c.Server.validate("server", true), c.AdminServer.validate("admin_server", false))
+	check(c.AdminServer.Port == "" || c.AdminServer.Addr() != c.Server.Addr(), "admin_server.port: must differ from server.port")
+	errs = append(errs, c.LookupLimits.validate("limits.lookup"), c.AdminLimits.validate("limits.admin"))
+
+	errs = append(errs,
+		validateURL("downstream.url", c.DownstreamURL),
+		validateURL("downstream.health_url", c.DownstreamHealthURL),
+		validateURL("downstream.auth.token_url", c.DownstreamAuth.TokenURL),
+		validateURL("auth.jwks_url", c.Auth.JWKSURL))
+	check(c.DownstreamAuth.TokenURL == "" || c.DownstreamAuth.ClientID != "", "downstream.auth.client_id: required with downstream.auth.token_url")
+
+	t := c.DownstreamTransport
+	check(t.MaxIdleConns >= 0 && t.MaxIdleConnsPerHost >= 0 && t.MaxConnsPerHost >= 0, "downstream.transport: connection limits must not be negative")
+	r := c.DownstreamRateLimit
+	check(r.PerSecond >= 0 && r.Burst >= 0 && r.PerDay >= 0 && r.PerCallerPerSecond >= 0, "downstream.rate_limit: limits must not be negative")
+	check(r.MaxWait >= 0, "downstream.rate_limit.max_wait: must not be negative")
+
+	in := c.InboundRateLimit
+	check(in.Limit >= 0 && in.Burst >= 0, "inbound_rate_limit: limits must not be negative")
+	if in.Limit > 0 {
+		check(in.Window > 0, "inbound_rate_limit.window: must be positive")
+		check(in.Algorithm == ratelimit.AlgorithmTokenBucket || in.Algorithm == ratelimit.AlgorithmSlidingWindow,
+			"inbound_rate_limit.algorithm: must be %s or %s", ratelimit.AlgorithmTokenBucket, ratelimit.AlgorithmSlidingWindow)
+		if _, err := ratelimit.KeyFromSources(in.KeySources); err != nil {
+			errs = append(errs, fmt.Errorf("inbound_rate_limit.key_sources: %w", err))
+		}
+	}
+
+	check(c.Auth.APIKeyStore == "" || c.Auth.APIKeyPepper != "", "auth.api_key_pepper: required with auth.api_key_store")
+	check(c.Auth.BootstrapAPIKey == "" || c.Auth.APIKeyStore != "", "auth.bootstrap_api_key: requires auth.api_key_store")
+	check(c.Auth.JWKSURL == "" || c.Auth.JWKSRefresh > 0, "auth.jwks_refresh: must be positive")
+	check(c.Auth.Leeway >= 0, "auth.leeway: must not be negative")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls: cert_file and key_file must be set together")
+	check(c.TLS.MinVersion == "1.2" || c.TLS.MinVersion == "1.3", "tls.min_version: must be 1.2 or 1.3")
+	switch c.TLS.ClientAuth {
+	case tlsconfig.ClientAuthNone:
+	case tlsconfig.ClientAuthRequest, tlsconfig.ClientAuthRequire:
+		check(c.TLS.ClientCAFile != "", "tls.client_ca_file: required to verify client certificates")
+		check(c.TLS.Enabled(), "tls.client_auth: requires tls.cert_file and tls.key_file")
+	default:
+		errs = append(errs, fmt.Errorf("tls.client_auth: must be %s, %s or %s", tlsconfig.ClientAuthNone, tlsconfig.ClientAuthRequest, tlsconfig.ClientAuthRequire))
+	}
+	check(!c.TLS.Enabled() || c.TLSReloadInterval > 0, "tls.reload_interval: must be positive")
+
+	check(c.Lifecycle.PreStopDelay >= 0, "shutdown.pre_stop_delay: must not be negative")
+	check(c.Lifecycle.DrainTimeout > 0, "shutdown.drain_timeout: must be positive")
+	check(c.Lifecycle.HookTimeout > 0, "shutdown.hook_timeout: must be positive")
+
+	for _, encoding := range c.Compression.Encodings {
+		check(encoding == compression.EncodingZstd || encoding == compression.EncodingGzip || encoding == compression.EncodingDeflate,
+			"compression.encodings: unsupported encoding %q; use %s, %s or %s", encoding, compression.EncodingZstd, compression.EncodingGzip, compression.EncodingDeflate)
+	}
+	check(c.LookupCache.MaxETagSize >= 0, "lookup_cache.max_etag_size: must not be negative")
+	check(c.Compression.MinSize >= 0, "compression.min_size: must not be negative")
+	if err := c.CORS.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("cors.allowed_origins: %w", err))
+	}
+	check(c.CORS.MaxAge >= 0, "cors.max_age: must not be negative")
+
+	for _, f := range c.FeatureFlags {
+		check(f.Percentage >= 0 && f.Percentage <= 100, "feature_flags.%s.percentage: must be between 0 and 100", f.Name)
+	}
+	return errors.Join(errs...)
+}
+
+func (c ServerConfig) validate(key string, required bool) error {
+	var errs []error
+	if c.Port != "" || required {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s.port: %q is not a port number", key, c.Port))
+		}
+	}
+	if c.ReadTimeout < 0 || c.ReadHeaderTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s: timeouts must not be negative", key))
+	}
+	if c.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("%s.max_header_bytes: must not be negative", key))
+	}
+	return errors.Join(errs...)
+}
+
+func (l RouteLimits) validate(key string) error {
+	if l.MaxBodyBytes < 0 || l.Timeout < 0 {
+		return fmt.Errorf("%s: limits must not be negative", key)
+	}
+	return nil
+}
+
+func validateURL(key, s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: %q is not an http or https URL", key, s)
+	}
+	return nil
+}
@@ -4,118 +4,296 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/StephenGriese/stdlibapp/auth"
+	"github.com/StephenGriese/stdlibapp/compression"
+	"github.com/StephenGriese/stdlibapp/config"
+	"github.com/StephenGriese/stdlibapp/cors"
 	"github.com/StephenGriese/stdlibapp/dictionary"
+	"github.com/StephenGriese/stdlibapp/featureflag"
+	"github.com/StephenGriese/stdlibapp/health"
+	"github.com/StephenGriese/stdlibapp/httpcache"
 	"github.com/StephenGriese/stdlibapp/kitmetrics"
+	"github.com/StephenGriese/stdlibapp/lifecycle"
+	"github.com/StephenGriese/stdlibapp/limits"
 	"github.com/StephenGriese/stdlibapp/logs"
 	"github.com/StephenGriese/stdlibapp/metrics"
+	"github.com/StephenGriese/stdlibapp/middleware"
+	"github.com/StephenGriese/stdlibapp/problem"
+	"github.com/StephenGriese/stdlibapp/recovery"
+	"github.com/StephenGriese/stdlibapp/requestid"
+	"github.com/StephenGriese/stdlibapp/tlsconfig"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
-	"io"
-	"strconv"
 
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
-	"sync"
+	"reflect"
+	"syscall"
 	"time"
 )
 
 const (
-	labelEndpoint = "endpoint"
-	labelStatus   = "status"
+	labelKeySource = "key_source"
 )
 
 func main() {
 	ctx := context.Background()
-	if err := run(ctx, os.Getenv); err != nil {
+	if err := run(ctx, os.Args, os.Getenv, os.Stdout); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(1)
 	}
 }
 
-type Config struct {
-	AppName       string
-	Port          string
-	DownstreamURL string
-}
-
 func run(
 	ctx context.Context,
+	args []string,
 	getenv func(string) string,
+	stdout io.Writer,
 ) error {
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	// Kubernetes sends SIGTERM to stop a pod; SIGINT is for local runs
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer func() {
 		log.Println("cancelling run's notify context")
 		cancel()
 	}()
 
-	config := createConfig(getenv)
+	loader, err := config.NewLoader(args[1:], getenv)
+	if err != nil {
+		return err
+	}
+	config, err := createConfig(loader)
+	if loader.PrintConfig() {
+		if err := loader.Print(stdout); err != nil {
+			return err
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
 
-	logger := logs.NewLogger()
+	live := newLiveConfig(config)
+	logger := logs.NewLeveledLogger(newLogLevel(live))
 
 	tracer := otel.Tracer(config.AppName)
 	logger.Info(ctx, "got tracer", "tracer", tracer)
 
 	metricsFactory := metrics.NewFactory(config.AppName)
 
-	srv := NewServer(ctx, config, logger, metricsFactory, tracer)
-	httpServer := &http.Server{
-		Addr:    net.JoinHostPort("localhost", config.Port),
-		Handler: srv,
+	downstreamClient := newDownstreamClient(live, logger, metricsFactory)
+
+	healthChecks := health.NewRegistry()
+	registerHealthChecks(healthChecks, config)
+
+	srv, adminSrv, err := NewServer(ctx, live, logger, metricsFactory, tracer, downstreamClient, healthChecks)
+	if err != nil {
+		return err
 	}
-	go func() {
-		logger.Info(ctx, "starting http server", "addr", httpServer.Addr)
-		log.Printf("listening on %s", httpServer.Addr)
-		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			fmt.Fprintf(os.Stderr, "error listening and serving: %s\n", err)
+	reloader := newConfigReloader(args, getenv, logger, metricsFactory, live, loader)
+	go reloader.watch(ctx, loader.Path(), config.ConfigReloadInterval)
+
+	lm := lifecycle.NewManager(config.Lifecycle, logger)
+	httpServer := newHTTPServer(config.Server, srv)
+	if config.TLS.Enabled() {
+		tlsConfig, reloader, err := tlsconfig.New(config.TLS)
+		if err != nil {
+			return err
 		}
-	}()
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		<-ctx.Done()
-		// Make a new context for the Shutdown (thanks Alessandro Rosetti)
-		shutdownCtx := context.Background()
-		shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-		defer cancel()
-		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			fmt.Fprintf(os.Stderr, "error shutting down http server: %s\n", err)
-		} else {
-			fmt.Fprintf(os.Stdout, "http server shutdown\n")
-		}
-	}()
-	wg.Wait()
+		httpServer.TLSConfig = tlsConfig
+		go reloader.Watch(ctx, config.TLSReloadInterval, func(err error) {
+			logger.Info(ctx, "failed to reload TLS certificate", "err", err)
+		})
+		// The certificate comes from TLSConfig.GetCertificate
+		lm.AddServer(httpServer, func(ln net.Listener) error { return httpServer.ServeTLS(ln, "", "") })
+	} else {
+		lm.AddServer(httpServer, httpServer.Serve)
+	}
+	if adminSrv != nil {
+		adminServer := newHTTPServer(config.AdminServer, adminSrv)
+		lm.AddServer(adminServer, adminServer.Serve)
+	}
+
+	lm.OnStopping(healthChecks.MarkShuttingDown)
+	lm.OnShutdown("flush tracer", func(ctx context.Context) error {
+		return shutdownProvider(ctx, otel.GetTracerProvider())
+	})
+	lm.OnShutdown("flush metric exporters", func(ctx context.Context) error {
+		return shutdownProvider(ctx, otel.GetMeterProvider())
+	})
+	return lm.Run(ctx, healthChecks.MarkStarted)
+}
+
+// shutdownProvider flushes and shuts down an OpenTelemetry provider. The SDK providers support this; the no-op
+// providers installed when nothing is configured do not need it.
+func shutdownProvider(ctx context.Context, provider any) error {
+	if p, ok := provider.(interface{ Shutdown(context.Context) error }); ok {
+		return p.Shutdown(ctx)
+	}
 	return nil
 }
 
+func newHTTPServer(config ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              config.Addr(),
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+}
+
 func NewServer(
 	ctx context.Context,
-	config Config,
+	live *liveConfig,
 	logger dictionary.Logger,
 	metricsFactory metrics.Factory,
 	tracer trace.Tracer,
-) http.Handler {
+	downstreamClient *http.Client,
+	healthChecks *health.Registry,
+) (http.Handler, http.Handler, error) {
+	config := live.Load()
 	mux := http.NewServeMux()
-	addRoutes(ctx, mux, config, logger, metricsFactory, tracer)
-	var handler http.Handler = mux
-	return handler
+	adminMux := mux
+	if config.AdminServer.Port != "" {
+		adminMux = http.NewServeMux()
+	}
+
+	// Every route, including /metrics and the probes, gets the standard middleware in the standard order
+	panics := metricsFactory.NewCounter("http_server", "panics_total", "Number of panics recovered from handlers", nil)
+	global := middleware.NewChain(
+		func(next http.Handler) http.Handler {
+			return recovery.NewHandler(logger, panics, next)
+		},
+		requestid.NewHandler,
+		middleware.Tracing(tracer),
+		middleware.Metrics(newRequestLatencyHistogram(metricsFactory)),
+		withCORS(config.CORS),
+		withCompression(config.Compression),
+		tlsconfig.WithClientCertificate,
+	)
+	router := middleware.NewRouter(mux, global)
+	adminRouter := router
+	if adminMux != mux {
+		adminRouter = middleware.NewRouter(adminMux, global)
+	}
+	if err := addRoutes(ctx, router, adminRouter, live, logger, metricsFactory, tracer, downstreamClient, healthChecks); err != nil {
+		return nil, nil, err
+	}
+	if adminRouter == router {
+		return router, nil, nil
+	}
+	return router, adminRouter, nil
 }
 
 func addRoutes(
 	ctx context.Context,
-	mux *http.ServeMux,
-	config Config,
+	router *middleware.Router,
+	adminRouter *middleware.Router,
+	live *liveConfig,
 	logger dictionary.Logger,
 	metricsFactory metrics.Factory,
 	tracer trace.Tracer,
-) {
-	histogram := newRequestLatencyHistogram(metricsFactory)
+	downstreamClient *http.Client,
+	healthChecks *health.Registry,
+) error {
+	config := live.Load()
+	rateLimited := newRateLimitedCounter(metricsFactory)
+	timedOut := newTimedOutCounter(metricsFactory)
 
-	mux.Handle("/lookup", withMetrics(histogram, "lookup", handleLookup(ctx, logger, config.DownstreamURL, metricsFactory.NewServiceStatistics("lookup"), tracer)))
-	mux.Handle("/metrics", handleGetMetrics(ctx, logger, metricsFactory))
+	inboundRateLimiter, err := newInboundRateLimiter(live, rateLimited)
+	if err != nil {
+		return err
+	}
+	var authenticators []auth.Authenticator
+	if verifier := newVerifier(config.Auth); verifier != nil {
+		authenticators = append(authenticators, auth.BearerAuthenticator{Verifier: verifier})
+	}
+	var apiKeys *auth.APIKeys
+	if config.Auth.APIKeyStore != "" {
+		if apiKeys, err = newAPIKeys(config.Auth, metricsFactory); err != nil {
+			return err
+		}
+		if config.Auth.BootstrapAPIKey != "" {
+			created, err := apiKeys.Bootstrap(config.Auth.BootstrapAPIKey, "bootstrap", config.Auth.AdminScopes)
+			if err != nil {
+				return fmt.Errorf("auth.bootstrap_api_key: %w", err)
+			}
+			if created {
+				logger.Info(ctx, "added the bootstrap API key to the store")
+			}
+		}
+		authenticators = append(authenticators, apiKeys)
+	}
+	adminAuth := withAuth(authenticators, config.Auth.AdminScopes)
+	adminLimits := withLimits(config.AdminLimits, http.StatusServiceUnavailable, timedOut)
+	if apiKeys != nil {
+		adminRouter.Handle("POST /admin/apikeys", handleCreateAPIKey(logger, apiKeys), adminAuth, adminLimits)
+		adminRouter.Handle("GET /admin/apikeys", handleListAPIKeys(apiKeys), adminAuth, adminLimits)
+		adminRouter.Handle("DELETE /admin/apikeys/{id}", handleRevokeAPIKey(logger, apiKeys), adminAuth, adminLimits)
+	}
+	featureFlags := newFeatureFlags(live, metricsFactory)
+	// Without an authenticator anyone could flip flags, so they can then only be changed in the config
+	if adminAuth != nil {
+		adminRouter.Handle("GET /admin/flags", handleListFeatureFlags(featureFlags), adminAuth, adminLimits)
+		adminRouter.Handle("PUT /admin/flags/{name}/override", handleOverrideFeatureFlag(logger, featureFlags), adminAuth, adminLimits)
+		adminRouter.Handle("DELETE /admin/flags/{name}/override", handleClearFeatureFlagOverride(logger, featureFlags), adminAuth, adminLimits)
+	}
+	downstreamURL := func() string { return live.Load().DownstreamURL }
+	lookup := handleLookup(ctx, logger, downstreamURL, downstreamClient, tracer)
+	lookupMiddleware := []middleware.Middleware{
+		withAuth(authenticators, config.Auth.LookupScopes),
+		withFeatureFlags(featureFlags),
+		inboundRateLimiter.middleware,
+		// The lookup waits on the dictionary, so running out of time is a gateway timeout
+		withLimits(config.LookupLimits, http.StatusGatewayTimeout, timedOut),
+		withLookupCache(live),
+	}
+	// Any method, since lookups are passed on to the dictionary with their method and body
+	router.Handle("/lookup", lookup, lookupMiddleware...)
+	router.Handle("/lookup/{word}", lookup, lookupMiddleware...)
+	adminRouter.Handle("GET /metrics", handleGetMetrics(ctx, logger, metricsFactory))
+	adminRouter.Handle("GET /livez", healthChecks.LivenessHandler())
+	adminRouter.Handle("GET /readyz", healthChecks.ReadinessHandler())
+	adminRouter.Handle("GET /startupz", healthChecks.StartupHandler())
+	// Profiles expose internals and are costly to take, so they are only served on a separate admin listener, which
+	// binds to localhost by default
+	if adminRouter != router {
+		addPprofRoutes(adminRouter)
+	}
+	return nil
+}
+
+func registerHealthChecks(healthChecks *health.Registry, config Config) {
+	if config.DownstreamHealthURL != "" {
+		// A plain client, so that probes neither use up the downstream quota nor skew the lookup metrics
+		client := &http.Client{Timeout: 2 * time.Second}
+		healthChecks.Register(health.Check{
+			Name:    "dictionary",
+			Func:    health.HTTPCheck(client, config.DownstreamHealthURL),
+			Timeout: 2 * time.Second,
+			// Lookups fail without the dictionary, but every pod shares it, so pulling them all out of rotation
+			// would not help
+			Critical: false,
+		})
+	}
+}
+
+// addPprofRoutes registers the net/http/pprof handlers, which would otherwise only be on http.DefaultServeMux
+func addPprofRoutes(router *middleware.Router) {
+	router.HandleFunc("GET /debug/pprof/", pprof.Index)
+	router.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	// go tool pprof looks symbols up with POST
+	router.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
 }
 
 func handleGetMetrics(ctx context.Context, logger dictionary.Logger, metricsFactory metrics.Factory) http.Handler {
@@ -125,124 +303,250 @@ func handleGetMetrics(ctx context.Context, logger dictionary.Logger, metricsFact
 	})
 }
 
-func handleLookup(ctx context.Context, logger dictionary.Logger, downstreamURL string, serviceStatistics metrics.ServiceStatistics, tracer trace.Tracer) http.Handler {
-	if downstreamURL == "" {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx, span := tracer.Start(ctx, "handleLookup")
+// handleLookup proxies lookups to the dictionary. downstreamURL is called for every request, so that a reloaded
+// config takes effect straight away.
+func handleLookup(ctx context.Context, logger dictionary.Logger, downstreamURL func() string, client *http.Client, tracer trace.Tracer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamURL := downstreamURL()
+		if downstreamURL == "" {
+			ctx, span := tracer.Start(r.Context(), "handleLookup")
 			defer span.End()
 			logger.Info(ctx, "handleLookup called", "downstreamURL", downstreamURL)
 			w.Write([]byte("hey!! lookup"))
-		})
-	} else {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx, span := tracer.Start(ctx, "handleLookup")
+		} else {
+			ctx, span := tracer.Start(r.Context(), "handleLookup")
 			defer span.End()
 			logger.Info(ctx, "handleLookup called", "downstreamURL", downstreamURL)
-			// Define the URL of the external server
-			externalURL := downstreamURL + r.URL.Path
+			// Downstream quotas are shared fairly between callers
+			if caller := r.Header.Get("X-Client-Id"); caller != "" {
+				ctx = dictionary.WithCaller(ctx, caller)
+			}
 
 			// Create a new request to the external server
-			req, err := http.NewRequest("GET", externalURL, nil)
+			req, err := newProxyRequest(r.WithContext(ctx), downstreamURL)
 			if err != nil {
 				http.Error(w, "Failed to create request", http.StatusInternalServerError)
 				return
 			}
 
-			// Optionally, you can copy headers from the original request to the new request
-			for key, values := range r.Header {
-				for _, value := range values {
-					req.Header.Add(key, value)
-				}
-			}
-
-			// Create an HTTP client
-			client := &http.Client{
-				Timeout:   10 * time.Second,
-				Transport: dictionary.LoggingRoundTripper{Proxied: http.DefaultTransport, Statistic: serviceStatistics},
-			}
-
 			// Make the request to the external server
 			resp, err := client.Do(req)
-			if err != nil {
-				http.Error(w, "Failed to get response from external server", http.StatusInternalServerError)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Error(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body is larger than %d bytes", tooLarge.Limit))
+				return
+			}
+			if errors.Is(err, dictionary.ErrRateLimited) {
+				http.Error(w, "Downstream rate limit exceeded", http.StatusServiceUnavailable)
 				return
 			}
-			defer resp.Body.Close()
-
-			// Read the response body
-			body, err := io.ReadAll(resp.Body)
 			if err != nil {
-				http.Error(w, "Failed to read response body", http.StatusInternalServerError)
+				http.Error(w, "Failed to get response from external server", http.StatusBadGateway)
 				return
 			}
+			defer resp.Body.Close()
 
-			// Write the response from the external server back to the client
-			for key, values := range resp.Header {
-				for _, value := range values {
-					w.Header().Add(key, value)
-				}
+			// Stream the response from the external server back to the client
+			if err := copyResponse(w, resp); err != nil {
+				logger.Info(ctx, "failed to copy response body", "err", err)
+				// The status line has gone out, so abort the connection rather than let the client, and any cache,
+				// take the partial body for a whole one, as httputil.ReverseProxy does
+				panic(http.ErrAbortHandler)
 			}
-			w.WriteHeader(resp.StatusCode)
-			w.Write(body)
-		})
+		}
+	})
+}
+
+// newDownstreamClient returns the http.Client shared by every downstream call. There is no overall client timeout
+// because proxied bodies are streamed; DownstreamTransport.ResponseHeaderTimeout bounds the wait for a response.
+func newDownstreamClient(live *liveConfig, logger dictionary.Logger, metricsFactory metrics.Factory) *http.Client {
+	config := live.Load()
+	base := dictionary.NewTransport(config.DownstreamTransport, metricsFactory.NewConnPoolStatistics("http_client"))
+	transport := base
+	if config.DownstreamAuth.TokenURL != "" {
+		tokenSource := dictionary.NewClientCredentialsTokenSource(config.DownstreamAuth, &http.Client{Transport: base, Timeout: 10 * time.Second})
+		transport = dictionary.TokenRoundTripper{Source: tokenSource, Proxied: transport}
+	}
+	transport = dictionary.RequestIDRoundTripper{Proxied: transport}
+	// The policy runs before the token and request ID are attached, so that they are not filtered out
+	transport = dictionary.HeaderPolicyRoundTripper{Policy: config.HeaderPolicy, Proxied: transport}
+	transport = dictionary.RateLimitingRoundTripper{
+		Limiter: newDownstreamRateLimiter(live, metricsFactory),
+		Proxied: transport,
+	}
+	return &http.Client{
+		Transport: dictionary.LoggingRoundTripper{
+			Logger:    logger,
+			Operation: dictionary.OperationFromContext(dictionary.StaticOperation("LookupWord")),
+			Proxied:   transport,
+			Statistic: metricsFactory.NewClientStatistics("lookup"),
+			Phases:    metricsFactory.NewRequestPhaseStatistics("http_client"),
+		},
+		// Redirects are the caller's business; hand them back untouched
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// newDownstreamRateLimiter returns the limiter that keeps us within the quotas of the dictionary. It is reconfigured
+// when they change.
+func newDownstreamRateLimiter(live *liveConfig, metricsFactory metrics.Factory) *dictionary.RateLimiter {
+	limiter := dictionary.NewRateLimiter(downstreamRateLimiterConfig(live.Load().DownstreamRateLimit), metricsFactory.NewRateLimitStatistics("http_client"))
+	live.OnChange(func(old, next Config) {
+		if old.DownstreamRateLimit != next.DownstreamRateLimit {
+			limiter.Reconfigure(downstreamRateLimiterConfig(next.DownstreamRateLimit))
+		}
+	})
+	return limiter
+}
+
+func downstreamRateLimiterConfig(config DownstreamRateLimitConfig) dictionary.RateLimiterConfig {
+	return dictionary.RateLimiterConfig{
+		Global: []dictionary.RateLimit{
+			{Limit: config.PerSecond, Per: time.Second, Burst: config.Burst},
+			{Limit: config.PerDay, Per: 24 * time.Hour},
+		},
+		PerKey: []dictionary.RateLimit{
+			{Limit: config.PerCallerPerSecond, Per: time.Second},
+		},
+		Key:     dictionary.CallerFromContext,
+		MaxWait: config.MaxWait,
 	}
 }
 
-func createConfig(getenv func(string) string) Config {
-	port := getenv("PORT")
-	downstreamURL := getenv("DOWNSTREAM_URL")
-	return Config{
-		Port:          port,
-		DownstreamURL: downstreamURL,
+func newVerifier(config AuthConfig) *auth.Verifier {
+	if config.JWKSURL == "" && config.HMACSecret == "" {
+		return nil
+	}
+	verifierConfig := auth.VerifierConfig{
+		Issuer:   config.Issuer,
+		Audience: config.Audience,
+		Leeway:   config.Leeway,
+	}
+	if config.JWKSURL != "" {
+		verifierConfig.Keys = auth.NewJWKS(config.JWKSURL, &http.Client{Timeout: 10 * time.Second}, config.JWKSRefresh)
 	}
+	if config.HMACSecret != "" {
+		verifierConfig.HMACSecret = []byte(config.HMACSecret)
+	}
+	return auth.NewVerifier(verifierConfig)
 }
 
-func withMetrics(histogram kitmetrics.Histogram, label string, handler http.Handler) http.Handler {
-	return metricHandler{
-		histogram: histogram,
-		label:     metrics.CanonicalLabel(label),
-		handler:   handler,
+func newAPIKeys(config AuthConfig, metricsFactory metrics.Factory) (*auth.APIKeys, error) {
+	store, err := auth.NewFileKeyStore(config.APIKeyStore)
+	if err != nil {
+		return nil, err
 	}
+	usage := metricsFactory.NewCounter("auth", "api_key_request_count", "Number of requests authenticated by each API key",
+		[]string{"key_id", "owner"})
+	return auth.NewAPIKeys(store, []byte(config.APIKeyPepper), usage), nil
 }
 
-type metricHandler struct {
-	handler   http.Handler
-	label     string
-	histogram kitmetrics.Histogram
+// newFeatureFlags returns the flags defined in the config, which follow it when it is reloaded. Overrides set through
+// the admin endpoints survive reloads.
+func newFeatureFlags(live *liveConfig, metricsFactory metrics.Factory) *featureflag.Set {
+	evaluations := metricsFactory.NewCounter("feature_flag", "evaluations_total", "Number of feature flag evaluations",
+		[]string{"flag", "result", "reason"})
+	flags := featureflag.NewSet(live.Load().FeatureFlags, evaluations)
+	live.OnChange(func(old, next Config) {
+		if !reflect.DeepEqual(old.FeatureFlags, next.FeatureFlags) {
+			flags.Update(next.FeatureFlags)
+		}
+	})
+	return flags
 }
 
-// ServeHTTP implements the http.Handler interface.
-func (mh metricHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	sw := newStatusCapturingResponseWriter(rw)
+// withFeatureFlags lets handlers evaluate flags with featureflag.Enabled. It must come after withAuth: the client is
+// identified by the client ID of its token, or else by the X-Client-Id header.
+func withFeatureFlags(flags *featureflag.Set) middleware.Middleware {
+	clientID := func(r *http.Request) string {
+		if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.ClientID != "" {
+			return claims.ClientID
+		}
+		return r.Header.Get("X-Client-Id")
+	}
+	return func(next http.Handler) http.Handler {
+		return featureflag.NewHandler(flags, clientID, next)
+	}
+}
 
-	defer func(start time.Time) {
-		mh.histogram.With(labelEndpoint, mh.label, labelStatus, strconv.Itoa(sw.status)).Observe(float64(time.Since(start).Milliseconds()))
-	}(time.Now())
+// withAuth requires credentials granting scopes. It returns nil, which middleware.Chain skips, when authentication is
+// disabled.
+func withAuth(authenticators []auth.Authenticator, scopes []string) middleware.Middleware {
+	if len(authenticators) == 0 {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return auth.NewHandler(authenticators, scopes, next)
+	}
+}
 
-	mh.handler.ServeHTTP(sw, r)
+// withLookupCache adds caching headers to lookup responses and answers conditional lookups. It follows the config,
+// so that e.g. a new max-age applies without a restart.
+func withLookupCache(live *liveConfig) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpcache.NewHandler(live.Load().LookupCache, next).ServeHTTP(w, r)
+		})
+	}
 }
 
-// statusWriter implements http.ResponseWriter to capture the http response status code.
-type statusWriter struct {
-	http.ResponseWriter
-	status int
+// withCORS lets browsers call the server from the allowed origins, or returns nil when no origin is allowed. It is
+// global rather than per route because preflight requests use OPTIONS, which no route is registered for.
+func withCORS(config cors.Config) middleware.Middleware {
+	if len(config.AllowedOrigins) == 0 {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return cors.NewHandler(config, next)
+	}
 }
 
-func newStatusCapturingResponseWriter(rw http.ResponseWriter) *statusWriter {
-	return &statusWriter{ResponseWriter: rw}
+// withCompression compresses responses, or returns nil when compression is disabled. It runs inside the metrics
+// middleware, which sees the status but not the encoding.
+func withCompression(config compression.Config) middleware.Middleware {
+	if !config.Enabled {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return compression.NewHandler(config, next)
+	}
+}
+
+// withLimits bounds the body size and handling time of the requests to a route. Requests that time out are answered
+// with status and counted by endpoint.
+func withLimits(config RouteLimits, status int, timedOut kitmetrics.Counter) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		if config.Timeout > 0 {
+			next = limits.TimeoutHandler{
+				Timeout: config.Timeout,
+				Status:  status,
+				OnTimeout: func(r *http.Request) {
+					timedOut.With(middleware.LabelEndpoint, middleware.Route(r)).Add(1)
+				},
+				Handler: next,
+			}
+		}
+		if config.MaxBodyBytes > 0 {
+			next = limits.NewBodyHandler(config.MaxBodyBytes, next)
+		}
+		return next
+	}
 }
 
-func (w *statusWriter) WriteHeader(status int) {
-	w.status = status
-	w.ResponseWriter.WriteHeader(status)
+func newTimedOutCounter(mf metrics.Factory) kitmetrics.Counter {
+	return mf.NewCounter("http_server", "timed_out_count", "Number of requests that did not complete within their timeout",
+		[]string{middleware.LabelEndpoint})
 }
 
-func (w *statusWriter) Write(b []byte) (int, error) {
-	return w.ResponseWriter.Write(b)
+func newRateLimitedCounter(mf metrics.Factory) kitmetrics.Counter {
+	return mf.NewCounter("http_server", "rate_limited_count", "Number of requests rejected by the rate limiter",
+		[]string{middleware.LabelEndpoint, labelKeySource})
 }
 
 func newRequestLatencyHistogram(mf metrics.Factory) kitmetrics.Histogram {
 	buckets := []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000}
 	return mf.NewHistogram("http_server", "request_latency_milliseconds", "Total duration of http requests in milliseconds",
-		buckets, []string{labelEndpoint, labelStatus})
+		buckets, []string{middleware.LabelEndpoint, middleware.LabelStatus})
 }
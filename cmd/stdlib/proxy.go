@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/StephenGriese/stdlibapp/dictionary"
+)
+
+// newProxyRequest builds the request that is sent downstream for the incoming request r. The method, query string
+// and body of r are forwarded as-is.
+func newProxyRequest(r *http.Request, downstreamURL string) (*http.Request, error) {
+	target := downstreamURL + r.URL.Path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	body := r.Body
+	if r.ContentLength == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = r.ContentLength
+
+	req.Header = r.Header.Clone()
+	dictionary.RemoveHopHeaders(req.Header)
+	setForwardedHeaders(req.Header, r)
+
+	return req, nil
+}
+
+// setForwardedHeaders adds the de-facto standard X-Forwarded-* headers describing the incoming request r.
+func setForwardedHeaders(h http.Header, r *http.Request) {
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		h.Set("X-Forwarded-For", clientIP)
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	h.Set("X-Forwarded-Proto", proto)
+	h.Set("X-Forwarded-Host", r.Host)
+}
+
+// copyResponse writes the downstream response to w, streaming the body instead of buffering it. Trailers sent by
+// the downstream are passed through.
+func copyResponse(w http.ResponseWriter, resp *http.Response) error {
+	dictionary.RemoveHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	for key := range resp.Trailer {
+		w.Header().Add("Trailer", key)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if err := copyBody(w, resp.Body, flushImmediately(resp)); err != nil {
+		return err
+	}
+
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	return nil
+}
+
+// flushImmediately reports whether each chunk of the response body should be flushed to the client as soon as it
+// is read. This is the case for streamed responses whose length is not known up front.
+func flushImmediately(resp *http.Response) bool {
+	if resp.ContentLength == -1 {
+		return true
+	}
+	contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return strings.TrimSpace(contentType) == "text/event-stream"
+}
+
+func copyBody(w http.ResponseWriter, body io.Reader, flush bool) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if flush {
+				if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+					return err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
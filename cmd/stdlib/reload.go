@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/config"
+	"github.com/StephenGriese/stdlibapp/dictionary"
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+	"github.com/StephenGriese/stdlibapp/metrics"
+	"github.com/StephenGriese/stdlibapp/middleware"
+	"github.com/StephenGriese/stdlibapp/ratelimit"
+)
+
+// reloadableKeys are the settings, or prefixes of settings, that take effect without a restart
+var reloadableKeys = []string{
+	"downstream.url",
+	"feature_flags.",
+	"downstream.rate_limit.",
+	"inbound_rate_limit.",
+	"log.level",
+	"lookup_cache.",
+}
+
+func reloadable(key string) bool {
+	for _, k := range reloadableKeys {
+		if key == k || (strings.HasSuffix(k, ".") && strings.HasPrefix(key, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// liveConfig holds the current Config. Components read the settings that can change from it on every use, or
+// subscribe to changes with OnChange to rebuild what they derive from them.
+type liveConfig struct {
+	current atomic.Pointer[Config]
+
+	mu        sync.Mutex
+	listeners []func(old, new Config)
+}
+
+func newLiveConfig(c Config) *liveConfig {
+	live := &liveConfig{}
+	live.current.Store(&c)
+	return live
+}
+
+// Load returns the current Config
+func (c *liveConfig) Load() Config {
+	return *c.current.Load()
+}
+
+// OnChange registers f to be called with the old and new Config after every successful reload
+func (c *liveConfig) OnChange(f func(old, new Config)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, f)
+}
+
+func (c *liveConfig) store(next Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.Load()
+	c.current.Store(&next)
+	for _, f := range c.listeners {
+		f(old, next)
+	}
+}
+
+// configReloader reads the config again from the same sources as at startup, and swaps it in if it is valid
+type configReloader struct {
+	args   []string
+	getenv func(string) string
+	logger dictionary.Logger
+	live   *liveConfig
+
+	reloads     kitmetrics.Counter
+	lastSuccess kitmetrics.Gauge
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newConfigReloader(args []string, getenv func(string) string, logger dictionary.Logger, metricsFactory metrics.Factory, live *liveConfig, loader *config.Loader) *configReloader {
+	r := &configReloader{
+		args:    args,
+		getenv:  getenv,
+		logger:  logger,
+		live:    live,
+		reloads: metricsFactory.NewCounter("config", "reload_total", "Number of attempts to reload the config", []string{"result"}),
+		lastSuccess: metricsFactory.NewGauge("config", "last_reload_success_timestamp_seconds",
+			"Time of the last successful load of the config, in seconds since the epoch", nil),
+		values: loader.Values(),
+	}
+	// Loading the config at startup counts as a successful reload
+	r.lastSuccess.Set(float64(time.Now().Unix()))
+	return r
+}
+
+// watch reloads the config on SIGHUP and when the config file changes, until ctx is done
+func (r *configReloader) watch(ctx context.Context, path string, interval time.Duration) {
+	config.Watch(ctx, path, interval, func() {
+		r.reload(ctx)
+	})
+}
+
+func (r *configReloader) reload(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loader, err := config.NewLoader(r.args[1:], r.getenv)
+	var next Config
+	if err == nil {
+		next, err = createConfig(loader)
+	}
+	if err != nil {
+		r.reloads.With("result", "failure").Add(1)
+		r.logger.Info(ctx, "config reload rejected, keeping the current config", "err", err)
+		return
+	}
+
+	values := loader.Values()
+	r.logChanges(ctx, r.values, values)
+	r.values = values
+	r.live.store(next)
+	r.reloads.With("result", "success").Add(1)
+	r.lastSuccess.Set(float64(time.Now().Unix()))
+}
+
+// logChanges logs every setting that differs between old and new, including those that were removed, which show
+// with an empty new value. Secrets are compared redacted, so changing one only shows when it is set or cleared.
+func (r *configReloader) logChanges(ctx context.Context, old, new map[string]string) {
+	keys := make([]string, 0, len(new))
+	for key := range new {
+		if old[key] != new[key] {
+			keys = append(keys, key)
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok && old[key] != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		r.logger.Info(ctx, "config reloaded, nothing changed")
+		return
+	}
+	for _, key := range keys {
+		r.logger.Info(ctx, "config changed", "key", key, "old", old[key], "new", new[key], "applied", reloadable(key))
+	}
+}
+
+// newLogLevel returns the level of the logger, which follows log.level
+func newLogLevel(live *liveConfig) *slog.LevelVar {
+	level := new(slog.LevelVar)
+	level.Set(parseLogLevel(live.Load().LogLevel))
+	live.OnChange(func(_, next Config) {
+		level.Set(parseLogLevel(next.LogLevel))
+	})
+	return level
+}
+
+// parseLogLevel parses a level that Validate has accepted
+func parseLogLevel(s string) slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(s))
+	return level
+}
+
+// inboundRateLimiter limits the requests of each client to a route. Its limiter is reconfigured in place when the
+// config changes, so that clients keep what they have used of their quota; only switching algorithms starts every
+// client over.
+type inboundRateLimiter struct {
+	rejected kitmetrics.Counter
+	// current is nil while rate limiting is disabled
+	current atomic.Pointer[inboundLimit]
+}
+
+type inboundLimit struct {
+	limiter ratelimit.Limiter
+	keyFunc ratelimit.KeyFunc
+}
+
+func newInboundRateLimiter(live *liveConfig, rejected kitmetrics.Counter) (*inboundRateLimiter, error) {
+	l := &inboundRateLimiter{rejected: rejected}
+	if err := l.update(live.Load().InboundRateLimit); err != nil {
+		return nil, err
+	}
+	live.OnChange(func(old, next Config) {
+		if !reflect.DeepEqual(old.InboundRateLimit, next.InboundRateLimit) {
+			// Validate has checked the settings, so this does not fail
+			_ = l.update(next.InboundRateLimit)
+		}
+	})
+	return l, nil
+}
+
+func (l *inboundRateLimiter) update(config InboundRateLimitConfig) error {
+	if config.Limit == 0 {
+		l.current.Store(nil)
+		return nil
+	}
+	keyFunc, err := ratelimit.KeyFromSources(config.KeySources)
+	if err != nil {
+		return err
+	}
+	var limiter ratelimit.Limiter
+	if current := l.current.Load(); current != nil {
+		limiter = current.limiter
+	}
+	if limiter, err = ratelimit.Update(limiter, config.Algorithm, config.Limit, config.Window, config.Burst); err != nil {
+		return err
+	}
+	l.current.Store(&inboundLimit{limiter: limiter, keyFunc: keyFunc})
+	return nil
+}
+
+// middleware limits the requests to a route. Every route it is used for shares the limiter. Rejections are counted
+// by endpoint and by the source of the client's key, e.g. "jwt" or "ip".
+func (l *inboundRateLimiter) middleware(next http.Handler) http.Handler {
+	onReject := func(r *http.Request, key string) {
+		source, _, _ := strings.Cut(key, ":")
+		l.rejected.With(middleware.LabelEndpoint, middleware.Route(r), labelKeySource, source).Add(1)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := l.current.Load()
+		if current == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ratelimit.NewHandler(current.limiter, current.keyFunc, onReject, next).ServeHTTP(w, r)
+	})
+}
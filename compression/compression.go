@@ -0,0 +1,297 @@
+// Package compression compresses responses with gzip, deflate or zstd, whichever the client prefers. Responses
+// that are already encoded, e.g. passed on from a downstream service that compressed them, are left as they are.
+package compression
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The supported content codings
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingZstd    = "zstd"
+)
+
+// Config says which responses to compress and how
+type Config struct {
+	// Enabled turns compression on
+	Enabled bool
+	// Encodings are the content codings offered, most preferred first. The client's q-values decide; the order only
+	// breaks ties.
+	Encodings []string
+	// MinSize is the size in bytes below which a response is not worth compressing
+	MinSize int
+	// ContentTypes are the media types that are compressed, e.g. "application/json". A type ending in "/*" matches
+	// every subtype, e.g. "text/*".
+	ContentTypes []string
+}
+
+// An encoder is a pooled compressor
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders hands out compressors, which are expensive enough to create, zstd ones especially, to be worth reusing
+var encoders = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	// The "deflate" content coding is the zlib format (RFC 9110, 8.4.1.2), not a raw deflate stream
+	EncodingDeflate: {New: func() any {
+		return zlib.NewWriter(nil)
+	}},
+	EncodingZstd: {New: func() any {
+		// One goroutine per response rather than one per CPU, and a window browsers accept
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(8<<20))
+		return enc
+	}},
+}
+
+// NewHandler compresses the responses of handler that the client accepts in one of config.Encodings, that are at
+// least config.MinSize bytes long and have one of config.ContentTypes. Responses of those types get
+// "Vary: Accept-Encoding" whether they are compressed or not, so that caches keep the variants apart.
+//
+// The response is held back until MinSize bytes have been written, the handler flushes or it returns, whichever comes
+// first, so that the size is known before committing to an encoding.
+func NewHandler(config Config, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiate(r.Header.Get("Accept-Encoding"), config.Encodings)
+		if r.Method == http.MethodHead {
+			// There is no body to compress, and the headers must match those of a GET that was not compressed
+			encoding = ""
+		}
+		cw := &compressWriter{ResponseWriter: w, config: config, encoding: encoding}
+		handler.ServeHTTP(cw, r)
+		// An error means the client went away; there is nobody left to tell
+		_ = cw.close()
+	})
+}
+
+// negotiate returns the encoding of offered that the client prefers according to acceptEncoding, or "" for none
+func negotiate(acceptEncoding string, offered []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if coding != "" {
+			accepted[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range offered {
+		q, ok := accepted[encoding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressWriter decides whether to compress once it has seen the headers and enough of the body
+type compressWriter struct {
+	http.ResponseWriter
+	config Config
+	// encoding is the one the client prefers, or "" if it accepts none
+	encoding string
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses, e.g. 103 Early Hints, go out straight away
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if !cw.bodyAllowed() || cw.Header().Get("Content-Length") != "" {
+		// Either there is no body, or its size is known and the decision can be made now
+		cw.decide()
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf.Write(b)
+		if cw.buf.Len() >= cw.config.MinSize {
+			if err := cw.decideAndWrite(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// FlushError sends what has been written so far. A response still being held back is sent with the size seen so far.
+func (cw *compressWriter) FlushError() error {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		if err := cw.decideAndWrite(); err != nil {
+			return err
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter, e.g. to set deadlines
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close sends a response that is still held back and finishes the compressed stream
+func (cw *compressWriter) close() error {
+	if cw.status == 0 {
+		// The handler wrote nothing; let net/http send its implicit 200 as it would without this middleware
+		return nil
+	}
+	if !cw.decided {
+		if err := cw.decideAndWrite(); err != nil {
+			return err
+		}
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	encoders[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+func (cw *compressWriter) decideAndWrite() error {
+	cw.decide()
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// decide picks the encoding of the response from its headers and what has been written of its body, and sends the
+// headers
+func (cw *compressWriter) decide() {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		// Already encoded, e.g. by the downstream service; it has set Vary itself
+		cw.ResponseWriter.WriteHeader(cw.status)
+		return
+	}
+	if cw.bodyAllowed() && header.Get("Content-Type") == "" && cw.buf.Len() > 0 {
+		// net/http would sniff the type after this middleware had already decided
+		header.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+	if !cw.compressible(header.Get("Content-Type")) {
+		cw.ResponseWriter.WriteHeader(cw.status)
+		return
+	}
+	addVary(header, "Accept-Encoding")
+
+	if cw.encoding != "" && cw.bodyAllowed() && cw.status != http.StatusPartialContent && cw.size() >= cw.config.MinSize {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		// The compressed bytes differ from those the tag was computed for, but the representation is the same
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.enc = encoders[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// size is the size of the body: the announced length if there is one, otherwise what has been written so far
+func (cw *compressWriter) size() int {
+	if n, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil {
+		return n
+	}
+	return cw.buf.Len()
+}
+
+func (cw *compressWriter) bodyAllowed() bool {
+	return cw.status != http.StatusNoContent && cw.status != http.StatusNotModified
+}
+
+func (cw *compressWriter) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cw.config.ContentTypes {
+		if allowed == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds field to the Vary header unless it is already listed
+func addVary(header http.Header, field string) {
+	for _, v := range header.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
@@ -0,0 +1,505 @@
+// Package config loads settings from layered sources. From lowest to highest precedence they are the defaults, a
+// YAML, JSON or TOML file, the environment and command-line flags.
+//
+// Every setting has a dotted key, e.g. "server.read_timeout", which is its path in the file and, with underscores
+// turned into dashes, its flag: --server.read-timeout. Its environment variable is given separately, so that
+// established names such as PORT keep working.
+//
+// Secrets can also be read from a file, named by the key with a "_file" suffix or the variable with a "_FILE"
+// suffix, e.g. AUTH_HMAC_SECRET_FILE. This is how Kubernetes and Docker mount them.
+//
+// Errors are collected rather than returned one at a time, so that a broken deployment is fixed in one go.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The flags understood by every Loader
+const (
+	// FlagConfig names the config file. The CONFIG_FILE environment variable does the same.
+	FlagConfig = "config"
+	// FlagPrintConfig asks for the effective config to be printed instead of running
+	FlagPrintConfig = "print-config"
+)
+
+// EnvConfig names the config file
+const EnvConfig = "CONFIG_FILE"
+
+// Redacted replaces secrets in printed configs
+const Redacted = "REDACTED"
+
+// A Loader reads settings. Use its typed methods to read each setting, then Err to learn whether any was invalid.
+type Loader struct {
+	path   string
+	file   map[string]any
+	getenv func(string) string
+	flags  map[string]string
+
+	printConfig bool
+	// used records the keys and flags that were read, so that misspelt ones can be reported
+	used map[string]bool
+	// effective holds the value of every setting read, for Print
+	effective map[string]any
+	errs      []error
+}
+
+// NewLoader returns a Loader for the command line args, without the program name, and the environment. The config
+// file, if any, is read straight away; an error is returned if it cannot be read or parsed.
+func NewLoader(args []string, getenv func(string) string) (*Loader, error) {
+	l := &Loader{
+		file:      make(map[string]any),
+		getenv:    getenv,
+		used:      make(map[string]bool),
+		effective: make(map[string]any),
+	}
+	flags, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	l.flags = flags
+
+	if _, ok := flags[FlagPrintConfig]; ok {
+		l.printConfig = true
+		l.used["--"+FlagPrintConfig] = true
+	}
+	path := getenv(EnvConfig)
+	if v, ok := flags[FlagConfig]; ok {
+		path = v
+		l.used["--"+FlagConfig] = true
+	}
+	l.path = path
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		flatten("", values, l.file)
+	}
+	return l, nil
+}
+
+// Path returns the path of the config file, or "" if there is none
+func (l *Loader) Path() string {
+	return l.path
+}
+
+// PrintConfig reports whether --print-config was given
+func (l *Loader) PrintConfig() bool {
+	return l.printConfig
+}
+
+// parseFlags accepts --name=value, --name value and, for switches, a bare --name
+func parseFlags(args []string) (map[string]string, error) {
+	flags := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			return nil, fmt.Errorf("unexpected argument %q", arg)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			value, hasValue = args[i+1], true
+			i++
+		}
+		if !hasValue {
+			value = "true"
+		}
+		flags[name] = value
+	}
+	return flags, nil
+}
+
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		// Keep numbers exact; float64 would print large ones in exponent form
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("unsupported format %q; use .yaml, .json or .toml", ext)
+	}
+	return values, err
+}
+
+// flatten turns nested tables into dotted keys
+func flatten(prefix string, values map[string]any, into map[string]any) {
+	for k, v := range values {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]any); ok {
+			flatten(key, nested, into)
+			continue
+		}
+		into[key] = v
+	}
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// lookup returns the raw value of key from the source with the highest precedence that has it
+func (l *Loader) lookup(key, env string) (any, bool) {
+	// A key in the file is known even when a higher source overrides it
+	if _, ok := l.file[key]; ok {
+		l.used[key] = true
+	}
+	if v, ok := l.flags[flagName(key)]; ok {
+		l.used["--"+flagName(key)] = true
+		return v, true
+	}
+	if env != "" {
+		if v := l.getenv(env); v != "" {
+			return v, true
+		}
+	}
+	if v, ok := l.file[key]; ok {
+		return v, true
+	}
+	return nil, false
+}
+
+func (l *Loader) fail(key, env string, err error) {
+	name := key
+	if env != "" {
+		name += " (" + env + ")"
+	}
+	l.errs = append(l.errs, fmt.Errorf("%s: %w", name, err))
+}
+
+// scalar returns the string form of a value read from any source
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case time.Time:
+		// YAML and TOML read unquoted timestamps as times
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		return "", fmt.Errorf("expected a single value, got %T", v)
+	}
+}
+
+// String reads a string setting
+func (l *Loader) String(key, env, def string) string {
+	s := def
+	if v, ok := l.lookup(key, env); ok {
+		var err error
+		if s, err = scalar(v); err != nil {
+			l.fail(key, env, err)
+			s = def
+		}
+	}
+	l.effective[key] = s
+	return s
+}
+
+// Int reads an integer setting
+func (l *Loader) Int(key, env string, def int) int {
+	i := def
+	if v, ok := l.lookup(key, env); ok {
+		s, err := scalar(v)
+		if err == nil {
+			i, err = strconv.Atoi(s)
+		}
+		if err != nil {
+			l.fail(key, env, err)
+			i = def
+		}
+	}
+	l.effective[key] = i
+	return i
+}
+
+// Bool reads a boolean setting
+func (l *Loader) Bool(key, env string, def bool) bool {
+	b := def
+	if v, ok := l.lookup(key, env); ok {
+		s, err := scalar(v)
+		if err == nil {
+			b, err = strconv.ParseBool(s)
+		}
+		if err != nil {
+			l.fail(key, env, err)
+			b = def
+		}
+	}
+	l.effective[key] = b
+	return b
+}
+
+// Duration reads a duration setting, e.g. "1m30s"
+func (l *Loader) Duration(key, env string, def time.Duration) time.Duration {
+	d := def
+	if v, ok := l.lookup(key, env); ok {
+		s, err := scalar(v)
+		if err == nil {
+			d, err = time.ParseDuration(s)
+		}
+		if err != nil {
+			l.fail(key, env, err)
+			d = def
+		}
+	}
+	l.effective[key] = d.String()
+	return d
+}
+
+// List reads a list setting. In the environment and on the command line it is comma-separated; in a file it may
+// also be a list.
+func (l *Loader) List(key, env string, def []string) []string {
+	list := def
+	if v, ok := l.lookup(key, env); ok {
+		var err error
+		if list, err = toList(v); err != nil {
+			l.fail(key, env, err)
+			list = def
+		}
+	}
+	l.effective[key] = list
+	return list
+}
+
+func toList(v any) ([]string, error) {
+	items, ok := v.([]any)
+	if !ok {
+		s, err := scalar(v)
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, err := scalar(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// Map reads a setting of names to values. In the environment and on the command line it is a comma-separated list
+// of name=value pairs; in a file it is a table.
+func (l *Loader) Map(key, env string) map[string]string {
+	m := make(map[string]string)
+	raw, ok := l.lookup(key, env)
+	if !ok {
+		// A table in the file was flattened into one key per entry
+		for k, v := range l.file {
+			name, found := strings.CutPrefix(k, key+".")
+			if !found {
+				continue
+			}
+			l.used[k] = true
+			s, err := scalar(v)
+			if err != nil {
+				l.fail(k, "", err)
+				continue
+			}
+			m[name] = s
+		}
+		l.effective[key] = m
+		return m
+	}
+
+	list, err := toList(raw)
+	if err != nil {
+		l.fail(key, env, err)
+	}
+	for _, pair := range list {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			l.fail(key, env, fmt.Errorf("%q is not a name=value pair", pair))
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	l.effective[key] = m
+	return m
+}
+
+// Names returns the sorted names of the tables nested under key in the file, e.g. "a" and "b" for a.x and b.y under
+// key. It is for settings that define a variable set of named items, which only a file can do.
+func (l *Loader) Names(key string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for k := range l.file {
+		rest, found := strings.CutPrefix(k, key+".")
+		if !found {
+			continue
+		}
+		name, _, nested := strings.Cut(rest, ".")
+		if nested && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Secret reads a string setting that is redacted by Print. It can also be read from the file named by the key with
+// a "_file" suffix or env with a "_FILE" suffix; the value itself takes precedence at the same level.
+func (l *Loader) Secret(key, env string) string {
+	s, err := l.secret(key, env)
+	if err != nil {
+		l.fail(key, env, err)
+	}
+	l.effective[key] = ""
+	if s != "" {
+		l.effective[key] = Redacted
+	}
+	return s
+}
+
+func (l *Loader) secret(key, env string) (string, error) {
+	for _, k := range []string{key, key + "_file"} {
+		if _, ok := l.file[k]; ok {
+			l.used[k] = true
+		}
+	}
+	if v, ok := l.flags[flagName(key)]; ok {
+		l.used["--"+flagName(key)] = true
+		return v, nil
+	}
+	if path, ok := l.flags[flagName(key+"_file")]; ok {
+		l.used["--"+flagName(key+"_file")] = true
+		return readSecret(path)
+	}
+	if env != "" {
+		if v := l.getenv(env); v != "" {
+			return v, nil
+		}
+		if path := l.getenv(env + "_FILE"); path != "" {
+			return readSecret(path)
+		}
+	}
+	if v, ok := l.file[key]; ok {
+		return scalar(v)
+	}
+	if v, ok := l.file[key+"_file"]; ok {
+		path, err := scalar(v)
+		if err != nil {
+			return "", err
+		}
+		return readSecret(path)
+	}
+	return "", nil
+}
+
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// Files written by editors and echo end with a newline that is not part of the secret
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Errorf records a validation error, so that it is reported along with the others by Err
+func (l *Loader) Errorf(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+// Err returns every error met so far, including keys in the file and flags that match no setting. It should be
+// called once every setting has been read.
+func (l *Loader) Err() error {
+	errs := l.errs
+	var unknown []string
+	for key := range l.file {
+		if !l.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	for name := range l.flags {
+		if !l.used["--"+name] {
+			unknown = append(unknown, "--"+name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown setting", name))
+	}
+	return errors.Join(errs...)
+}
+
+// Values returns the effective value of every setting read by its key, with secrets redacted. Lists and maps are
+// rendered with fmt, so that values can be compared as strings.
+func (l *Loader) Values() map[string]string {
+	values := make(map[string]string, len(l.effective))
+	for key, v := range l.effective {
+		values[key] = fmt.Sprint(v)
+	}
+	return values
+}
+
+// Print writes the effective value of every setting read as YAML, which can be used as a config file once the
+// secrets are filled in
+func (l *Loader) Print(w io.Writer) error {
+	root := make(map[string]any)
+	for key, v := range l.effective {
+		parts := strings.Split(key, ".")
+		table := root
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := table[part].(map[string]any)
+			if !ok {
+				next = make(map[string]any)
+				table[part] = next
+			}
+			table = next
+		}
+		table[parts[len(parts)-1]] = v
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
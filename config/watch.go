@@ -0,0 +1,52 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch calls reload whenever the process gets SIGHUP, and whenever the file at path changes, which is checked every
+// interval. An empty path only watches for the signal. Watch returns when ctx is done.
+//
+// Files are polled rather than watched for events because Kubernetes updates mounted ConfigMaps by swapping a
+// symlink, which event-based watchers easily miss.
+func Watch(ctx context.Context, path string, interval time.Duration, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	var modTime time.Time
+	if path != "" {
+		modTime = modTimeOf(path)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload()
+		case <-tick:
+			// os.Stat follows the symlink, so a swapped target shows up as a new modification time
+			if t := modTimeOf(path); !t.IsZero() && !t.Equal(modTime) {
+				modTime = t
+				reload()
+			}
+		}
+	}
+}
+
+func modTimeOf(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		// A file being replaced may briefly be missing; the reload would fail, so wait for it to reappear
+		return time.Time{}
+	}
+	return info.ModTime()
+}
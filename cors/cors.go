@@ -0,0 +1,175 @@
+// Package cors lets browsers call the server from pages on other origins, following the Fetch standard's CORS
+// protocol.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/middleware"
+	"github.com/StephenGriese/stdlibapp/problem"
+)
+
+// Config says which origins may call the server and how
+type Config struct {
+	// AllowedOrigins are the origins allowed to make requests, e.g. "https://dictionary.example.com". "*" allows
+	// every origin, and a "*." in front of the host allows every subdomain, e.g. "https://*.example.com".
+	AllowedOrigins []string
+	// AllowedMethods are the methods a preflight may ask for
+	AllowedMethods []string
+	// AllowedHeaders are the request headers a preflight may ask for; "*" allows every header
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers, beyond the CORS-safelisted ones, that scripts may read
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and the Authorization header. It cannot be combined with an
+	// allowed origin of "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache the result of a preflight; zero leaves it to the browser
+	MaxAge time.Duration
+}
+
+// Validate reports origins that cannot be matched and a wildcard origin combined with credentials
+func (c Config) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf("%q cannot be allowed with credentials", origin)
+			}
+			continue
+		}
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme == "" || host == "" || strings.Contains(host, "/") || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("%q is not an origin, e.g. https://example.com or https://*.example.com", origin)
+		}
+	}
+	return nil
+}
+
+// NewHandler answers CORS preflight requests for the routes of handler, and adds the CORS headers to the responses
+// to allowed origins. Requests from other origins are served without them, which keeps browsers from reading the
+// responses.
+//
+// Preflights are only answered for routes that exist, which relies on the middleware.Router matching a preflight
+// to the route it asks about. Preflights for unknown routes are passed on to handler.
+func NewHandler(config Config, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if middleware.Route(r) == middleware.Unmatched {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			preflight(config, w, r)
+			return
+		}
+
+		if config.allowOrigin(origin) {
+			setAllowOrigin(config, header, origin)
+			if len(config.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers a preflight request. A preflight that asks for more than is allowed gets 403 Forbidden with a
+// problem explaining why; browsers only report that CORS failed, but the response shows up in their developer tools.
+func preflight(config Config, w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	if !config.allowOrigin(origin) {
+		problem.Error(w, r, http.StatusForbidden, fmt.Sprintf("origin %s is not allowed", origin))
+		return
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !slices.Contains(config.AllowedMethods, method) {
+		problem.Error(w, r, http.StatusForbidden, fmt.Sprintf("method %s is not allowed", method))
+		return
+	}
+	requested := requestedHeaders(r)
+	for _, h := range requested {
+		if !config.allowHeader(h) {
+			problem.Error(w, r, http.StatusForbidden, fmt.Sprintf("header %s is not allowed", h))
+			return
+		}
+	}
+
+	setAllowOrigin(config, header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		// Echo the headers asked for rather than sending "*", which browsers do not honour with credentials
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if config.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setAllowOrigin(config Config, header http.Header, origin string) {
+	if slices.Contains(config.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c Config) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// https://*.example.com matches https://a.example.com and https://a.b.example.com, but not
+		// https://example.com
+		if prefix, suffix, ok := strings.Cut(allowed, "*."); ok &&
+			len(origin) > len(prefix)+len(suffix)+1 && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, "."+suffix) {
+			sub := origin[len(prefix) : len(origin)-len(suffix)-1]
+			if !strings.ContainsAny(sub, "/:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c Config) allowHeader(name string) bool {
+	for _, allowed := range c.AllowedHeaders {
+		if allowed == "*" || strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedHeaders returns the headers listed in Access-Control-Request-Headers
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				headers = append(headers, h)
+			}
+		}
+	}
+	return headers
+}
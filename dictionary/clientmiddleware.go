@@ -1,32 +1,64 @@
 package dictionary
 
 import (
-	"fmt"
 	"github.com/StephenGriese/stdlibapp/metrics"
 	"net/http"
 	"time"
 )
 
+// LoggingRoundTripper is client middle-ware that logs and measures every request sent to a dependency. It can wrap
+// the transport of any outbound client.
 type LoggingRoundTripper struct {
-	Statistic metrics.ServiceStatistics
-	Proxied   http.RoundTripper
+	Logger Logger
+	// Operation derives the label used for logs and metrics. When nil, requests are labeled by HTTP method.
+	Operation OperationFunc
+	Statistic metrics.ClientStatistics
+	// Phases, if set, receives the duration of each phase of the request: DNS lookup, connect, TLS handshake, time
+	// to first byte and body transfer. The phases are also added as events to the span in the request's context.
+	Phases  metrics.RequestPhaseStatistics
+	Proxied http.RoundTripper
 }
 
-func (lrt LoggingRoundTripper) RoundTrip(req *http.Request) (res *http.Response, e error) {
-	// Do "before sending requests" actions here.
-	defer func(begin time.Time) {
-		lrt.Statistic.Update("LookupWord", begin, e)
-	}(time.Now())
+func (lrt LoggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation := lrt.operation(req)
+	begin := time.Now()
 
-	// Send the request, get the response (or the error)
-	res, e = lrt.Proxied.RoundTrip(req)
+	var phases *phaseTracer
+	if lrt.Phases != nil {
+		phases = newPhaseTracer(req.Context(), operation, lrt.Phases)
+		req = req.WithContext(phases.withClientTrace(req.Context()))
+	}
+
+	res, err := lrt.Proxied.RoundTrip(req)
 
-	// Handle the result.
-	if e != nil {
-		fmt.Printf("Error: %v", e)
-	} else {
-		fmt.Printf("Received %v response\n", res.Status)
+	var statusCode int
+	if res != nil {
+		statusCode = res.StatusCode
+	}
+	lrt.Statistic.Update(operation, statusCode, begin, err)
+
+	fields := []any{
+		"operation", operation,
+		"method", req.Method,
+		"host", req.URL.Host,
+		"path", req.URL.Path,
+		"duration_ms", time.Since(begin).Milliseconds(),
+	}
+	if err != nil {
+		lrt.Logger.Info(req.Context(), "downstream request failed", append(fields, "err", err)...)
+		return nil, err
 	}
+	lrt.Logger.Info(req.Context(), "downstream response received", append(fields, "status", res.StatusCode)...)
 
-	return // TODO: fix the naked return
+	if phases != nil {
+		res.Body = phases.wrapBody(res.Body)
+	}
+	return res, nil
+}
+
+func (lrt LoggingRoundTripper) operation(req *http.Request) string {
+	if lrt.Operation == nil {
+		return MethodOperation(req)
+	}
+	return lrt.Operation(req)
 }
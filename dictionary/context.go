@@ -0,0 +1,29 @@
+package dictionary
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	operationKey contextKey = iota
+	callerKey
+)
+
+// WithOperation returns a copy of ctx that carries the operation name used by OperationFromContext
+func WithOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey, operation)
+}
+
+// WithCaller returns a copy of ctx that carries the caller (or tenant) on whose behalf downstream calls are made
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey, caller)
+}
+
+// CallerFromContext returns the caller stored by WithCaller. It can be used as RateLimiterConfig.Key.
+func CallerFromContext(req *http.Request) string {
+	caller, _ := req.Context().Value(callerKey).(string)
+	return caller
+}
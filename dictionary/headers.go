@@ -0,0 +1,108 @@
+package dictionary
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopHeaders are the hop-by-hop headers (RFC 9110, section 7.6.1). They are meaningful only for a single
+// transport-level connection, so a proxy must not forward them in either direction.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopHeaders deletes the hop-by-hop headers from h, including any listed in the Connection header
+func RemoveHopHeaders(h http.Header) {
+	for _, field := range h.Values("Connection") {
+		for _, name := range strings.Split(field, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// HeaderRules describe how the headers crossing a trust boundary in one direction are rewritten. They are applied in
+// field order: hop-by-hop headers are always removed first, then Allow (when not empty, only these headers pass),
+// Deny, Rename (old name to new name) and finally Set, which injects static headers.
+type HeaderRules struct {
+	Allow  []string
+	Deny   []string
+	Rename map[string]string
+	Set    map[string]string
+}
+
+// Apply rewrites h according to the rules
+func (r HeaderRules) Apply(h http.Header) {
+	RemoveHopHeaders(h)
+	if len(r.Allow) > 0 {
+		allowed := make(map[string]bool, len(r.Allow))
+		for _, name := range r.Allow {
+			allowed[textproto.CanonicalMIMEHeaderKey(name)] = true
+		}
+		for name := range h {
+			if !allowed[name] {
+				delete(h, name)
+			}
+		}
+	}
+	for _, name := range r.Deny {
+		h.Del(name)
+	}
+	for from, to := range r.Rename {
+		if values := h.Values(from); len(values) > 0 {
+			h.Del(from)
+			for _, value := range values {
+				h.Add(to, value)
+			}
+		}
+	}
+	for name, value := range r.Set {
+		h.Set(name, value)
+	}
+}
+
+// A HeaderPolicy decides which headers are forwarded to a downstream service and which come back from it
+type HeaderPolicy struct {
+	Request  HeaderRules
+	Response HeaderRules
+}
+
+// DefaultHeaderRequestDeny are the request headers that do not reach the downstream by default: the caller's
+// credentials and cookies belong to us, not to the downstream.
+var DefaultHeaderRequestDeny = []string{"Authorization", "Cookie", "X-Api-Key"}
+
+// DefaultHeaderResponseDeny are the response headers that do not reach the caller by default
+var DefaultHeaderResponseDeny = []string{"Set-Cookie"}
+
+// HeaderPolicyRoundTripper applies a HeaderPolicy to the requests it sends and the responses it receives. Placed in
+// the transport of the downstream client, it covers the proxy path and any typed client built on that transport.
+type HeaderPolicyRoundTripper struct {
+	Policy  HeaderPolicy
+	Proxied http.RoundTripper
+}
+
+func (rt HeaderPolicyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	rt.Policy.Request.Apply(req.Header)
+
+	res, err := rt.Proxied.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rt.Policy.Response.Apply(res.Header)
+	return res, nil
+}
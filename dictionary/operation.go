@@ -0,0 +1,75 @@
+package dictionary
+
+import (
+	"net/http"
+	"strings"
+)
+
+// An OperationFunc derives the operation label of an outbound request. The label is used for logs and metrics, so
+// it must have a bounded number of values.
+type OperationFunc func(req *http.Request) string
+
+// StaticOperation labels every request with the same operation
+func StaticOperation(operation string) OperationFunc {
+	return func(*http.Request) string {
+		return operation
+	}
+}
+
+// OperationFromContext labels requests with the operation stored by WithOperation, falling back to next
+func OperationFromContext(next OperationFunc) OperationFunc {
+	return func(req *http.Request) string {
+		if operation, ok := req.Context().Value(operationKey).(string); ok && operation != "" {
+			return operation
+		}
+		return next(req)
+	}
+}
+
+// OperationFromHeader labels requests with the value of the given request header, falling back to next
+func OperationFromHeader(header string, next OperationFunc) OperationFunc {
+	return func(req *http.Request) string {
+		if operation := req.Header.Get(header); operation != "" {
+			return operation
+		}
+		return next(req)
+	}
+}
+
+// OperationFromRoute labels requests with the first route template that matches the request path, falling back to
+// next. Templates use "{name}" for a single path segment, e.g. "/lookup/{word}".
+func OperationFromRoute(next OperationFunc, templates ...string) OperationFunc {
+	return func(req *http.Request) string {
+		for _, template := range templates {
+			if matchRoute(template, req.URL.Path) {
+				return template
+			}
+		}
+		return next(req)
+	}
+}
+
+// MethodOperation labels requests with their HTTP method
+func MethodOperation(req *http.Request) string {
+	return req.Method
+}
+
+func matchRoute(template, path string) bool {
+	want := strings.Split(strings.Trim(template, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if strings.HasPrefix(want[i], "{") && strings.HasSuffix(want[i], "}") {
+			if got[i] == "" {
+				return false
+			}
+			continue
+		}
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
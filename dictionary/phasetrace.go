@@ -0,0 +1,112 @@
+package dictionary
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// phaseTracer records the timing of each phase of one outbound request. The httptrace hooks may be called from
+// different goroutines, hence the mutex.
+type phaseTracer struct {
+	mu         sync.Mutex
+	methodName string
+	stats      metrics.RequestPhaseStatistics
+	span       trace.Span
+
+	dnsStart, connectStart, tlsStart, wroteRequest, firstByte time.Time
+}
+
+func newPhaseTracer(ctx context.Context, methodName string, stats metrics.RequestPhaseStatistics) *phaseTracer {
+	return &phaseTracer{methodName: methodName, stats: stats, span: trace.SpanFromContext(ctx)}
+}
+
+// withClientTrace returns a copy of ctx that reports to the phaseTracer
+func (p *phaseTracer) withClientTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { p.start(&p.dnsStart) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			p.done(metrics.PhaseDNSLookup, &p.dnsStart, info.Err)
+		},
+		ConnectStart: func(_, _ string) { p.start(&p.connectStart) },
+		ConnectDone: func(_, _ string, err error) {
+			p.done(metrics.PhaseConnect, &p.connectStart, err)
+		},
+		TLSHandshakeStart: func() { p.start(&p.tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			p.done(metrics.PhaseTLSHandshake, &p.tlsStart, err)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			p.start(&p.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			p.start(&p.firstByte)
+			p.done(metrics.PhaseFirstByte, &p.wroteRequest, nil)
+		},
+	})
+}
+
+// wrapBody returns a body that records the body transfer phase once it has been fully read or closed
+func (p *phaseTracer) wrapBody(body io.ReadCloser) io.ReadCloser {
+	return &phaseTracingBody{ReadCloser: body, tracer: p}
+}
+
+func (p *phaseTracer) start(t *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	*t = time.Now()
+}
+
+// done records a phase that started at *begin. Phases that never started, e.g. DNS on a reused connection, are
+// skipped.
+func (p *phaseTracer) done(phase string, begin *time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if begin.IsZero() {
+		return
+	}
+	d := time.Since(*begin)
+	p.stats.Observe(p.methodName, phase, d)
+
+	attrs := []attribute.KeyValue{attribute.Float64("duration_ms", float64(d.Nanoseconds())/float64(time.Millisecond))}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error", err.Error()))
+	}
+	p.span.AddEvent(phase, trace.WithTimestamp(*begin), trace.WithAttributes(attrs...))
+}
+
+type phaseTracingBody struct {
+	io.ReadCloser
+	once   sync.Once
+	tracer *phaseTracer
+}
+
+func (b *phaseTracingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *phaseTracingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *phaseTracingBody) finish(err error) {
+	b.once.Do(func() {
+		b.tracer.done(metrics.PhaseBodyTransfer, &b.tracer.firstByte, err)
+	})
+}
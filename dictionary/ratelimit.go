@@ -0,0 +1,361 @@
+package dictionary
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/metrics"
+)
+
+// ErrRateLimited is returned when a call cannot be made within its deadline without exceeding a rate limit
+var ErrRateLimited = errors.New("dictionary: rate limit exceeded")
+
+const (
+	scopeGlobal = "global"
+	scopeKey    = "key"
+
+	// maxIdleKeys bounds the number of per-key buckets kept around; full buckets are dropped beyond it
+	maxIdleKeys = 10000
+)
+
+// A RateLimit allows Limit calls every Per, with bursts of up to Burst calls. A zero Burst means Limit.
+type RateLimit struct {
+	Limit int
+	Per   time.Duration
+	Burst int
+}
+
+// RateLimiterConfig configures a RateLimiter. Global limits apply to every call; PerKey limits apply separately to
+// each key returned by Key, e.g. each caller or tenant.
+type RateLimiterConfig struct {
+	Global []RateLimit
+	PerKey []RateLimit
+	Key    func(req *http.Request) string
+	// MaxWait is the longest a call is held back. A call that would wait longer, e.g. until a daily quota resets, fails
+	// straight away. Zero leaves it to the deadline of the call's context, if it has one.
+	MaxWait time.Duration
+}
+
+// A RateLimiter is a client-side token-bucket limiter that keeps calls within the quotas of a downstream service.
+// It also backs off when the downstream says it is being called too often.
+type RateLimiter struct {
+	stats metrics.RateLimitStatistics
+
+	mu     sync.Mutex
+	config RateLimiterConfig
+	global []*tokenBucket
+	keyed  map[string][]*tokenBucket
+}
+
+// NewRateLimiter returns a new RateLimiter
+func NewRateLimiter(config RateLimiterConfig, stats metrics.RateLimitStatistics) *RateLimiter {
+	return &RateLimiter{
+		config: config,
+		stats:  stats,
+		global: newTokenBuckets(config.Global),
+		keyed:  make(map[string][]*tokenBucket),
+	}
+}
+
+// Reconfigure replaces the limits. A limit over the same period as an old one takes over what has been used of it,
+// scaled to its burst, and any pause; refilling it instead would let a change to, say, the per-second limit reset the
+// per-day quota. Limits over new periods start out full, as they do in a new RateLimiter.
+func (l *RateLimiter) Reconfigure(config RateLimiterConfig) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	l.global = carryOver(l.global, newTokenBuckets(config.Global), now)
+	for key, buckets := range l.keyed {
+		l.keyed[key] = carryOver(buckets, newTokenBuckets(config.PerKey), now)
+	}
+}
+
+// Wait blocks until req may be sent. It fails fast with ErrRateLimited if the wait would be longer than MaxWait or go
+// past the deadline of the request's context.
+func (l *RateLimiter) Wait(req *http.Request) error {
+	ctx := req.Context()
+	now := time.Now()
+	l.mu.Lock()
+	maxWait := l.config.MaxWait
+	l.mu.Unlock()
+
+	scope := scopeGlobal
+	global, keyed := l.buckets(req, now)
+	buckets := global
+	if len(keyed) > 0 {
+		buckets = append(append([]*tokenBucket{}, global...), keyed...)
+	}
+
+	var wait time.Duration
+	for _, b := range buckets {
+		if d := b.reserve(now); d > wait {
+			wait = d
+			scope = scopeGlobal
+			if !contains(global, b) {
+				scope = scopeKey
+			}
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	deadline, ok := ctx.Deadline()
+	if (maxWait > 0 && wait > maxWait) || (ok && now.Add(wait).After(deadline)) {
+		cancelAll(buckets)
+		l.stats.Throttled(scope, metrics.ThrottleRejected, wait)
+		return ErrRateLimited
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		l.stats.Throttled(scope, metrics.ThrottleDelayed, wait)
+		return nil
+	case <-ctx.Done():
+		cancelAll(buckets)
+		l.stats.Throttled(scope, metrics.ThrottleRejected, time.Since(now))
+		return ctx.Err()
+	}
+}
+
+// Observe adapts the limiter to the rate limit headers of a downstream response. Retry-After on a 429 or 503, or an
+// exhausted X-RateLimit-Remaining with an X-RateLimit-Reset, pauses all calls until the given time.
+func (l *RateLimiter) Observe(res *http.Response) {
+	now := time.Now()
+	var until time.Time
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		until = parseRetryAfter(res.Header.Get("Retry-After"), now)
+	}
+	if res.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset := parseRateLimitReset(res.Header.Get("X-RateLimit-Reset"), now); reset.After(until) {
+			until = reset
+		}
+	}
+	if until.IsZero() {
+		return
+	}
+	l.mu.Lock()
+	global := l.global
+	l.mu.Unlock()
+	for _, b := range global {
+		b.pauseUntil(until)
+	}
+}
+
+// buckets returns the global buckets and those of the key of req
+func (l *RateLimiter) buckets(req *http.Request, now time.Time) (global, keyed []*tokenBucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.config.PerKey) == 0 || l.config.Key == nil {
+		return l.global, nil
+	}
+	key := l.config.Key(req)
+	if key == "" {
+		return l.global, nil
+	}
+
+	buckets, ok := l.keyed[key]
+	if !ok {
+		if len(l.keyed) >= maxIdleKeys {
+			l.evictFull(now)
+		}
+		buckets = newTokenBuckets(l.config.PerKey)
+		l.keyed[key] = buckets
+	}
+	return l.global, buckets
+}
+
+// evictFull drops the buckets of keys that have been idle long enough to refill completely; recreating them later
+// yields the same state
+func (l *RateLimiter) evictFull(now time.Time) {
+	for key, buckets := range l.keyed {
+		full := true
+		for _, b := range buckets {
+			if !b.full(now) {
+				full = false
+				break
+			}
+		}
+		if full {
+			delete(l.keyed, key)
+		}
+	}
+}
+
+// RateLimitingRoundTripper holds back requests that would exceed the limits of a RateLimiter
+type RateLimitingRoundTripper struct {
+	Limiter *RateLimiter
+	Proxied http.RoundTripper
+}
+
+func (rt RateLimitingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := rt.Limiter.Wait(req); err != nil {
+		return nil, err
+	}
+	res, err := rt.Proxied.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rt.Limiter.Observe(res)
+	return res, nil
+}
+
+type tokenBucket struct {
+	// per is the period of the limit, which identifies the bucket across reconfigurations
+	per time.Duration
+
+	mu          sync.Mutex
+	rate        float64 // tokens per second
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBuckets(limits []RateLimit) []*tokenBucket {
+	buckets := make([]*tokenBucket, 0, len(limits))
+	now := time.Now()
+	for _, limit := range limits {
+		if limit.Limit <= 0 || limit.Per <= 0 {
+			continue
+		}
+		burst := limit.Burst
+		if burst <= 0 {
+			burst = limit.Limit
+		}
+		buckets = append(buckets, &tokenBucket{
+			per:    limit.Per,
+			rate:   float64(limit.Limit) / limit.Per.Seconds(),
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   now,
+		})
+	}
+	return buckets
+}
+
+// carryOver gives each of buckets the state of the first of old with the same period, and returns buckets
+func carryOver(old, buckets []*tokenBucket, now time.Time) []*tokenBucket {
+	taken := make([]bool, len(old))
+	for _, b := range buckets {
+		for i, o := range old {
+			if !taken[i] && o.per == b.per {
+				taken[i] = true
+				b.takeOver(o, now)
+				break
+			}
+		}
+	}
+	return buckets
+}
+
+// takeOver gives b the share of its burst that is left in old, and the pause of old
+func (b *tokenBucket) takeOver(old *tokenBucket, now time.Time) {
+	old.mu.Lock()
+	old.refill(now)
+	left := old.tokens / old.burst
+	pausedUntil := old.pausedUntil
+	old.mu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = left * b.burst
+	b.last = now
+	b.pausedUntil = pausedUntil
+}
+
+// reserve takes a token and returns how long the caller must wait before using it. The token count may go negative,
+// which queues callers behind each other.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if paused := b.pausedUntil.Sub(now); paused > wait {
+		wait = paused
+	}
+	return wait
+}
+
+// cancel returns a token taken by reserve
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+1, b.burst)
+}
+
+func (b *tokenBucket) pauseUntil(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t.After(b.pausedUntil) {
+		b.pausedUntil = t
+	}
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst && !b.pausedUntil.After(now)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*b.rate, b.burst)
+		b.last = now
+	}
+}
+
+func cancelAll(buckets []*tokenBucket) {
+	for _, b := range buckets {
+		b.cancel()
+	}
+}
+
+func contains(buckets []*tokenBucket, b *tokenBucket) bool {
+	for _, candidate := range buckets {
+		if candidate == b {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(v string, now time.Time) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// parseRateLimitReset parses an X-RateLimit-Reset header. Vendors disagree on whether it holds a Unix timestamp or a
+// number of seconds from now; values too large to be a delay are taken as timestamps.
+func parseRateLimitReset(v string, now time.Time) time.Time {
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}
+	}
+	const oneYear = 365 * 24 * 60 * 60
+	if seconds > oneYear {
+		return time.Unix(seconds, 0)
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
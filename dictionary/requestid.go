@@ -0,0 +1,24 @@
+package dictionary
+
+import (
+	"net/http"
+
+	"github.com/StephenGriese/stdlibapp/requestid"
+)
+
+// RequestIDRoundTripper passes the ID of the request being served on to the dependency, so that its logs can be
+// matched with ours. The ID in the request's context wins over any X-Request-ID the request already carries.
+type RequestIDRoundTripper struct {
+	Proxied http.RoundTripper
+}
+
+func (rt RequestIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := requestid.FromContext(req.Context())
+	if id == "" || req.Header.Get(requestid.Header) == id {
+		return rt.Proxied.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set(requestid.Header, id)
+	return rt.Proxied.RoundTrip(req)
+}
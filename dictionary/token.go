@@ -0,0 +1,179 @@
+package dictionary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTokenLifetime is assumed when the token endpoint does not say when a token expires
+	defaultTokenLifetime = 5 * time.Minute
+	// defaultRefreshBefore is how long before expiry a token is refreshed in the background
+	defaultRefreshBefore = time.Minute
+)
+
+// TokenSourceConfig configures a ClientCredentialsTokenSource. The client credentials are sent to TokenURL in the
+// X-Client-Id and X-Client-Secret headers.
+type TokenSourceConfig struct {
+	TokenURL      string
+	ClientID      string
+	ClientSecret  string
+	RefreshBefore time.Duration
+}
+
+// A ClientCredentialsTokenSource fetches OAuth2 access tokens with the client credentials grant and caches them.
+// Tokens are refreshed in the background shortly before they expire, and concurrent refreshes are collapsed into one
+// call to the token endpoint.
+type ClientCredentialsTokenSource struct {
+	config TokenSourceConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	inflight  *tokenFetch
+}
+
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewClientCredentialsTokenSource returns a new ClientCredentialsTokenSource that calls the token endpoint with
+// client
+func NewClientCredentialsTokenSource(config TokenSourceConfig, client *http.Client) *ClientCredentialsTokenSource {
+	if config.RefreshBefore <= 0 {
+		config.RefreshBefore = defaultRefreshBefore
+	}
+	return &ClientCredentialsTokenSource{config: config, client: client}
+}
+
+// Token returns a valid access token, fetching one if the cached token is missing or expired
+func (s *ClientCredentialsTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	now := time.Now()
+	if s.token != "" && now.Before(s.expiresAt) {
+		token := s.token
+		if now.After(s.expiresAt.Add(-s.config.RefreshBefore)) {
+			// Still valid, but refresh it now rather than making a caller wait once it expires
+			s.startFetch()
+		}
+		s.mu.Unlock()
+		return token, nil
+	}
+	f := s.startFetch()
+	s.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Invalidate drops the cached token, e.g. after the downstream rejected it
+func (s *ClientCredentialsTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// startFetch returns the in-flight fetch, starting one if there is none; it must be called with s.mu held
+func (s *ClientCredentialsTokenSource) startFetch() *tokenFetch {
+	if s.inflight != nil {
+		return s.inflight
+	}
+	f := &tokenFetch{done: make(chan struct{})}
+	s.inflight = f
+	go func() {
+		// The fetch outlives any one caller, so it is not bound to a caller's context
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		token, expiresAt, err := s.fetch(ctx)
+
+		s.mu.Lock()
+		if err == nil {
+			s.token, s.expiresAt = token, expiresAt
+		}
+		s.inflight = nil
+		s.mu.Unlock()
+
+		f.token, f.err = token, err
+		close(f.done)
+	}()
+	return f
+}
+
+func (s *ClientCredentialsTokenSource) fetch(ctx context.Context) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, http.NoBody)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("X-Client-Id", s.config.ClientID)
+	req.Header.Set("X-Client-Secret", s.config.ClientSecret)
+	req.Header.Set("Accept", "application/json")
+
+	begin := time.Now()
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("dictionary: fetching token: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("dictionary: fetching token: unexpected status %s", res.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("dictionary: decoding token: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, errors.New("dictionary: token response has no access_token")
+	}
+	lifetime := defaultTokenLifetime
+	if body.ExpiresIn > 0 {
+		lifetime = time.Duration(body.ExpiresIn) * time.Second
+	}
+	// Measure from when the request was sent so that the token is never used past its real expiry
+	return body.AccessToken, begin.Add(lifetime), nil
+}
+
+// TokenRoundTripper authenticates downstream requests with a bearer token from a ClientCredentialsTokenSource,
+// replacing any Authorization header already on the request
+type TokenRoundTripper struct {
+	Source  *ClientCredentialsTokenSource
+	Proxied http.RoundTripper
+}
+
+func (rt TokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := rt.Source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := rt.Proxied.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		rt.Source.Invalidate(token)
+	}
+	return res, nil
+}
@@ -0,0 +1,119 @@
+package dictionary
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/metrics"
+)
+
+// TransportConfig holds the settings of the http.Transport used for downstream calls
+type TransportConfig struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	DisableKeepAlives     bool
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	EnableHTTP2           bool
+}
+
+// NewTransport returns a new http.RoundTripper configured from cfg. It is meant to be created once and shared by
+// every request so that connections are pooled. The state of the pool is reported to stats.
+func NewTransport(cfg TransportConfig, stats metrics.ConnPoolStatistics) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			stats.ConnOpened()
+			return &trackedConn{Conn: conn, stats: stats}, nil
+		},
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     cfg.EnableHTTP2,
+	}
+	if !cfg.EnableHTTP2 {
+		// A non-nil, empty map disables HTTP/2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return connPoolRoundTripper{stats: stats, proxied: t}
+}
+
+// connPoolRoundTripper uses httptrace to report connection pool events to a ConnPoolStatistics
+type connPoolRoundTripper struct {
+	stats   metrics.ConnPoolStatistics
+	proxied http.RoundTripper
+}
+
+func (c connPoolRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var acquired bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			acquired = true
+			c.stats.ConnAcquired()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			c.stats.Dialed(err)
+		},
+	}
+
+	res, err := c.proxied.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		if acquired {
+			c.stats.ConnReleased()
+		}
+		return nil, err
+	}
+	if acquired {
+		// The connection is in use until the body has been read and closed
+		res.Body = &releasingBody{ReadCloser: res.Body, release: c.stats.ConnReleased}
+	}
+	return res, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+type trackedConn struct {
+	net.Conn
+	once  sync.Once
+	stats metrics.ConnPoolStatistics
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.stats.ConnClosed)
+	return err
+}
@@ -0,0 +1,218 @@
+// Package featureflag turns features on for some clients and not others, so that a change can be rolled out to a
+// growing share of clients and rolled back without a deploy.
+package featureflag
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrUnknownFlag is returned when overriding a flag that is not defined
+var ErrUnknownFlag = errors.New("featureflag: unknown flag")
+
+// A Flag decides for which clients a feature is on
+type Flag struct {
+	Name string
+	// Percentage is the share of clients, 0 to 100, the flag is on for. Clients are picked by a hash of the flag name
+	// and client ID, so a client gets the same result every time and raising the percentage only adds clients.
+	Percentage int
+	// Allow lists the client IDs the flag is always on for
+	Allow []string
+	// Deny lists the client IDs the flag is always off for; it takes precedence over Allow
+	Deny []string
+}
+
+// The reasons for the result of an evaluation
+const (
+	ReasonOverride = "override"
+	ReasonDeny     = "deny"
+	ReasonAllow    = "allow"
+	ReasonRollout  = "rollout"
+	// ReasonUnknown means the flag is not defined, which leaves it off
+	ReasonUnknown = "unknown"
+)
+
+// An Evaluation is the result of evaluating a flag for a client
+type Evaluation struct {
+	Flag    string
+	Enabled bool
+	Reason  string
+}
+
+// evaluate decides whether f is on for clientID. Without a client ID only a full rollout turns the flag on, since
+// there is nothing to keep the result stable between requests.
+func (f Flag) evaluate(clientID string) Evaluation {
+	e := Evaluation{Flag: f.Name}
+	switch {
+	case clientID != "" && slices.Contains(f.Deny, clientID):
+		e.Reason = ReasonDeny
+	case clientID != "" && slices.Contains(f.Allow, clientID):
+		e.Enabled, e.Reason = true, ReasonAllow
+	default:
+		e.Enabled, e.Reason = f.Percentage >= 100 || (clientID != "" && bucket(f.Name, clientID) < f.Percentage), ReasonRollout
+	}
+	return e
+}
+
+// bucket places clientID in one of 100 buckets, independently for every flag
+func bucket(flag, clientID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag))
+	h.Write([]byte{0})
+	h.Write([]byte(clientID))
+	return int(h.Sum32() % 100)
+}
+
+// A State is a flag as shown to operators, with its override if it has one
+type State struct {
+	Flag
+	Override *bool
+}
+
+// A Set holds the defined flags and the overrides set at runtime
+type Set struct {
+	evaluations kitmetrics.Counter
+
+	mu        sync.RWMutex
+	flags     map[string]Flag
+	overrides map[string]bool
+}
+
+// NewSet returns a Set of flags. Every evaluation is counted by evaluations, which may be nil, by flag, result
+// ("on" or "off") and reason.
+func NewSet(flags []Flag, evaluations kitmetrics.Counter) *Set {
+	s := &Set{evaluations: evaluations, overrides: make(map[string]bool)}
+	s.Update(flags)
+	return s
+}
+
+// Update replaces the defined flags. Overrides are kept for the flags that are still defined.
+func (s *Set) Update(flags []Flag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags = make(map[string]Flag, len(flags))
+	for _, f := range flags {
+		s.flags[f.Name] = f
+	}
+	for name := range s.overrides {
+		if _, ok := s.flags[name]; !ok {
+			delete(s.overrides, name)
+		}
+	}
+}
+
+// Override turns the flag on or off for every client until the override is cleared. Overrides only live in memory,
+// so they are lost on restart and each instance has its own.
+func (s *Set) Override(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.flags[name]; !ok {
+		return ErrUnknownFlag
+	}
+	s.overrides[name] = enabled
+	return nil
+}
+
+// ClearOverride returns the flag to its configured rollout
+func (s *Set) ClearOverride(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.flags[name]; !ok {
+		return ErrUnknownFlag
+	}
+	delete(s.overrides, name)
+	return nil
+}
+
+// List returns the state of every flag, sorted by name
+func (s *Set) List() []State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := make([]State, 0, len(s.flags))
+	for name, f := range s.flags {
+		state := State{Flag: f}
+		if enabled, ok := s.overrides[name]; ok {
+			state.Override = &enabled
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Evaluate decides whether the flag is on for clientID. The result is counted and added as an event to the span in
+// ctx.
+func (s *Set) Evaluate(ctx context.Context, name, clientID string) Evaluation {
+	e := s.evaluate(name, clientID)
+
+	result := "off"
+	if e.Enabled {
+		result = "on"
+	}
+	if s.evaluations != nil {
+		s.evaluations.With("flag", name, "result", result, "reason", e.Reason).Add(1)
+	}
+	// The attributes follow the OpenTelemetry semantic conventions for feature flags
+	trace.SpanFromContext(ctx).AddEvent("feature_flag.evaluation", trace.WithAttributes(
+		attribute.String("feature_flag.key", name),
+		attribute.String("feature_flag.result.variant", result),
+		attribute.String("feature_flag.result.reason", e.Reason),
+	))
+	return e
+}
+
+func (s *Set) evaluate(name, clientID string) Evaluation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.flags[name]
+	if !ok {
+		return Evaluation{Flag: name, Reason: ReasonUnknown}
+	}
+	if enabled, ok := s.overrides[name]; ok {
+		return Evaluation{Flag: name, Enabled: enabled, Reason: ReasonOverride}
+	}
+	return f.evaluate(clientID)
+}
+
+type contextKey int
+
+const (
+	evaluatorKey contextKey = iota
+)
+
+type evaluator struct {
+	set      *Set
+	clientID string
+}
+
+// WithSet returns a copy of ctx in which Enabled evaluates the flags of set for clientID
+func WithSet(ctx context.Context, set *Set, clientID string) context.Context {
+	return context.WithValue(ctx, evaluatorKey, evaluator{set: set, clientID: clientID})
+}
+
+// Enabled reports whether the flag is on for the client of the request ctx belongs to. Every flag is off in a
+// context without a Set.
+func Enabled(ctx context.Context, name string) bool {
+	e, ok := ctx.Value(evaluatorKey).(evaluator)
+	if !ok {
+		return false
+	}
+	return e.set.Evaluate(ctx, name, e.clientID).Enabled
+}
+
+// NewHandler makes the flags of set available to handler through Enabled. clientID returns the ID of the client of
+// a request, or "" when it is unknown; it runs after any middleware before this one, e.g. authentication.
+func NewHandler(set *Set, clientID func(r *http.Request) string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(WithSet(r.Context(), set, clientID(r))))
+	})
+}
@@ -1,18 +1,17 @@
 module github.com/StephenGriese/stdlibapp
 
-go 1.21
-
-toolchain go1.22.2
+go 1.23.0
 
 require (
-	github.com/go-kit/kit v0.9.0
+	github.com/BurntSushi/toml v1.6.0
+	github.com/klauspost/compress v1.18.0
 	github.com/prometheus/client_golang v1.14.0
 	go.opentelemetry.io/otel v1.27.0
 	go.opentelemetry.io/otel/trace v1.27.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/VividCortex/gohistogram v1.0.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/go-logr/logr v1.4.1 // indirect
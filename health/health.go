@@ -0,0 +1,224 @@
+// Package health serves the liveness, readiness and startup probes of the service. Components register named checks
+// with a Registry, which runs them concurrently, each under its own timeout, and reports a JSON breakdown.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultTimeout = time.Second
+
+var (
+	errShuttingDown = errors.New("shutting down")
+	errNotStarted   = errors.New("not started")
+)
+
+// A Check reports whether one component is healthy
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+	// Timeout bounds one run of Func; zero means one second
+	Timeout time.Duration
+	// Critical checks fail the probe; other failures are only reported
+	Critical bool
+	// Liveness checks also run for /livez. Keep them to things a restart would fix: a dependency being down is a
+	// reason to stop sending us traffic, not to restart us.
+	Liveness bool
+}
+
+// A Registry holds the checks of the service and serves the probes
+type Registry struct {
+	mu     sync.RWMutex
+	checks []Check
+
+	started      atomic.Bool
+	shuttingDown atomic.Bool
+}
+
+// NewRegistry returns a new, empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check
+func (r *Registry) Register(check Check) {
+	if check.Timeout <= 0 {
+		check.Timeout = defaultTimeout
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check)
+}
+
+// MarkStarted makes /startupz and /readyz succeed once their checks pass. It should be called once the service is
+// listening.
+func (r *Registry) MarkStarted() {
+	r.started.Store(true)
+}
+
+// MarkShuttingDown makes /readyz fail from now on, so that load balancers drain the service before it stops
+func (r *Registry) MarkShuttingDown() {
+	r.shuttingDown.Store(true)
+}
+
+// A Report is the JSON body of a probe response
+type Report struct {
+	Status string                 `json:"status"`
+	Error  string                 `json:"error,omitempty"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// A CheckResult is the outcome of one check
+type CheckResult struct {
+	Status     string `json:"status"`
+	Critical   bool   `json:"critical"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// LivenessHandler serves /livez
+func (r *Registry) LivenessHandler() http.Handler {
+	return r.handler(func(c Check) bool { return c.Liveness }, nil)
+}
+
+// ReadinessHandler serves /readyz. It fails while the service is starting or shutting down.
+func (r *Registry) ReadinessHandler() http.Handler {
+	return r.handler(func(Check) bool { return true }, func() error {
+		if r.shuttingDown.Load() {
+			return errShuttingDown
+		}
+		if !r.started.Load() {
+			return errNotStarted
+		}
+		return nil
+	})
+}
+
+// StartupHandler serves /startupz. It fails until the service has started.
+func (r *Registry) StartupHandler() http.Handler {
+	return r.handler(func(Check) bool { return true }, func() error {
+		if !r.started.Load() {
+			return errNotStarted
+		}
+		return nil
+	})
+}
+
+func (r *Registry) handler(include func(Check) bool, precondition func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := Report{Status: statusOK}
+		if precondition != nil {
+			if err := precondition(); err != nil {
+				report.Status = statusFail
+				report.Error = err.Error()
+			}
+		}
+
+		report.Checks = r.run(req.Context(), include)
+		for _, result := range report.Checks {
+			if result.Critical && result.Status == statusFail {
+				report.Status = statusFail
+			}
+		}
+
+		status := http.StatusOK
+		if report.Status == statusFail {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+func (r *Registry) run(ctx context.Context, include func(Check) bool) map[string]CheckResult {
+	r.mu.RLock()
+	checks := make([]Check, 0, len(r.checks))
+	for _, c := range r.checks {
+		if include(c) {
+			checks = append(checks, c)
+		}
+	}
+	r.mu.RUnlock()
+
+	results := make(map[string]CheckResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+			result := runCheck(ctx, c)
+			mu.Lock()
+			results[c.Name] = result
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	return results
+}
+
+func runCheck(ctx context.Context, c Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	begin := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Func(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		// A check that ignores its context must not hold up the probe
+		err = fmt.Errorf("timed out after %s", c.Timeout)
+	}
+
+	result := CheckResult{Status: statusOK, Critical: c.Critical, DurationMS: time.Since(begin).Milliseconds()}
+	if err != nil {
+		result.Status = statusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// HTTPCheck returns a check that succeeds when a GET of url answers with a status below 500
+func HTTPCheck(client *http.Client, url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+		if res.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return nil
+	}
+}
+
+// DBCheck returns a check that pings db, e.g. one tracked with metrics.Factory.TrackDBStatistics
+func DBCheck(db *sql.DB) func(ctx context.Context) error {
+	return db.PingContext
+}
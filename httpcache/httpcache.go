@@ -0,0 +1,241 @@
+// Package httpcache adds the validators and freshness information that let browsers, CDNs and other caches reuse
+// responses, and answers conditional requests for unchanged responses with 304 Not Modified.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config says how responses may be cached
+type Config struct {
+	// CacheControl is sent with responses that do not have a Cache-Control header, e.g. "public, max-age=3600".
+	// Empty sends none.
+	CacheControl string
+	// LastModified is sent with responses that do not have a Last-Modified header, e.g. the time the dictionary was
+	// last updated. The zero time sends none.
+	LastModified time.Time
+	// CredentialHeaders are the request headers that carry credentials, e.g. "Authorization". Responses to requests
+	// with any of them are marked private, so that shared caches do not hand them to other clients.
+	CredentialHeaders []string
+	// MaxETagSize is the size in bytes of the largest body that is given an ETag. Larger bodies, and streamed ones,
+	// are passed on as they are written instead of being held back to compute it.
+	MaxETagSize int
+}
+
+// NewHandler adds caching headers to the 200 OK responses of handler to GET and HEAD requests. Headers the handler
+// sets itself, e.g. when passing on the response of a downstream service, take precedence. A response without an
+// ETag gets a strong one derived from its body.
+//
+// When the request's If-None-Match or If-Modified-Since shows the client already has the response, it gets 304 Not
+// Modified instead.
+func NewHandler(config Config, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		cw := &cacheWriter{ResponseWriter: w, r: r, config: config}
+		handler.ServeHTTP(cw, r)
+		// An error means the client went away; there is nobody left to tell
+		_ = cw.close()
+	})
+}
+
+// cacheWriter holds back the body of a 200 response without an ETag until it can compute one
+type cacheWriter struct {
+	http.ResponseWriter
+	r      *http.Request
+	config Config
+
+	status int
+	buf    bytes.Buffer
+	// buffering is true while the body is held back
+	buffering bool
+	// discard is true once the client has been told its copy is current
+	discard bool
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses, e.g. 103 Early Hints, go out straight away
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if status != http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	header := cw.Header()
+	if cw.config.CacheControl != "" && header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", cw.config.CacheControl)
+	}
+	if cc := header.Get("Cache-Control"); cc != "" && cw.credentialed() {
+		// "public" would let shared caches serve the response to anyone (RFC 9111, 3.5)
+		header.Set("Cache-Control", private(cc))
+	}
+	if !cw.config.LastModified.IsZero() && header.Get("Last-Modified") == "" {
+		header.Set("Last-Modified", cw.config.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	size, err := strconv.Atoi(header.Get("Content-Length"))
+	switch {
+	case header.Get("ETag") != "" || cw.r.Method == http.MethodHead || (err == nil && size > cw.config.MaxETagSize):
+		// The ETag is known or cannot be computed, so the response can be sent, or not, right away
+		cw.writeHeader()
+	default:
+		cw.buffering = true
+	}
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case cw.discard:
+		return len(b), nil
+	case cw.buffering:
+		cw.buf.Write(b)
+		if cw.buf.Len() > cw.config.MaxETagSize {
+			if err := cw.stream(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	default:
+		return cw.ResponseWriter.Write(b)
+	}
+}
+
+// FlushError gives up on the ETag of a response that is being held back, since the handler wants it sent as it is
+// written
+func (cw *cacheWriter) FlushError() error {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		if err := cw.stream(); err != nil {
+			return err
+		}
+	}
+	if cw.discard {
+		return nil
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter, e.g. to set deadlines
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close sends a response that is still held back, with the ETag of its body
+func (cw *cacheWriter) close() error {
+	if !cw.buffering {
+		return nil
+	}
+	cw.buffering = false
+	sum := sha256.Sum256(cw.buf.Bytes())
+	cw.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	cw.writeHeader()
+	if cw.discard {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buf.Bytes())
+	return err
+}
+
+// stream sends a response that was held back without an ETag, and passes on the rest of the body as it is written
+func (cw *cacheWriter) stream() error {
+	cw.buffering = false
+	cw.writeHeader()
+	_, err := cw.ResponseWriter.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// writeHeader sends the headers of the 200 response, or 304 Not Modified if the client's copy is current
+func (cw *cacheWriter) writeHeader() {
+	if notModified(cw.r, cw.Header()) {
+		cw.discard = true
+		header := cw.Header()
+		// A 304 carries the headers a cache needs to update its copy, and no body
+		header.Del("Content-Length")
+		header.Del("Content-Type")
+		cw.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	cw.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+func (cw *cacheWriter) credentialed() bool {
+	for _, h := range cw.config.CredentialHeaders {
+		if cw.r.Header.Get(h) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// private turns the Cache-Control directives cc into ones that only allow private caches to store the response
+func private(cc string) string {
+	directives := []string{"private"}
+	for _, d := range strings.Split(cc, ",") {
+		d = strings.TrimSpace(d)
+		name, _, _ := strings.Cut(d, "=")
+		switch {
+		case strings.EqualFold(name, "no-store"):
+			// Nothing may store it anyway
+			return cc
+		case d == "", strings.EqualFold(name, "public"), strings.EqualFold(name, "private"), strings.EqualFold(name, "s-maxage"):
+		default:
+			directives = append(directives, d)
+		}
+	}
+	return strings.Join(directives, ", ")
+}
+
+// notModified evaluates the request's If-None-Match, or else its If-Modified-Since, against the response headers
+// (RFC 9110, 13.2.2)
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakMatch(candidate, etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ims)
+}
+
+// weakMatch compares entity tags ignoring whether they are weak, as If-None-Match requires. Compressing a response
+// weakens its ETag, and a client holding the compressed copy must still get 304.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
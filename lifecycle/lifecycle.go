@@ -0,0 +1,168 @@
+// Package lifecycle runs the servers of the service and stops them gracefully: readiness is failed first so that
+// load balancers stop sending traffic, in-flight requests are drained under a deadline, and exporters are flushed.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/dictionary"
+)
+
+// Config holds the shutdown timings
+type Config struct {
+	// PreStopDelay is how long the servers keep serving after readiness starts failing, giving load balancers time to
+	// notice
+	PreStopDelay time.Duration
+	// DrainTimeout bounds the wait for in-flight requests to complete
+	DrainTimeout time.Duration
+	// HookTimeout bounds the shutdown hooks, e.g. flushing exporters
+	HookTimeout time.Duration
+}
+
+// A Manager runs servers until its context is cancelled or one of them fails, then shuts everything down in order
+type Manager struct {
+	config Config
+	logger dictionary.Logger
+
+	servers  []server
+	stopping []func()
+	hooks    []hook
+}
+
+type server struct {
+	srv   *http.Server
+	serve func(ln net.Listener) error
+}
+
+type hook struct {
+	name string
+	f    func(ctx context.Context) error
+}
+
+// NewManager returns a new Manager
+func NewManager(config Config, logger dictionary.Logger) *Manager {
+	return &Manager{config: config, logger: logger}
+}
+
+// AddServer adds a server that listens on srv.Addr. serve is typically srv.Serve, or a call to srv.ServeTLS.
+func (m *Manager) AddServer(srv *http.Server, serve func(ln net.Listener) error) {
+	m.servers = append(m.servers, server{srv: srv, serve: serve})
+}
+
+// OnStopping registers f to be called as soon as shutdown begins, before the pre-stop delay
+func (m *Manager) OnStopping(f func()) {
+	m.stopping = append(m.stopping, f)
+}
+
+// OnShutdown registers f to be called after the servers have drained. Hooks run in the order they were registered.
+func (m *Manager) OnShutdown(name string, f func(ctx context.Context) error) {
+	m.hooks = append(m.hooks, hook{name: name, f: f})
+}
+
+// Run starts the servers and blocks until ctx is done or a server fails to serve, then shuts down. started, if set,
+// is called once every server is listening. An address that cannot be listened on is returned straight away;
+// otherwise the error of a failed server is returned, joined with any errors from shutting down.
+func (m *Manager) Run(ctx context.Context, started func()) error {
+	listeners := make([]net.Listener, 0, len(m.servers))
+	for _, s := range m.servers {
+		ln, err := listen(s.srv.Addr)
+		if err != nil {
+			for _, ln := range listeners {
+				_ = ln.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	errc := make(chan error, len(m.servers))
+	for i, s := range m.servers {
+		go func(s server, ln net.Listener) {
+			m.logger.Info(ctx, "starting http server", "addr", ln.Addr().String())
+			if err := s.serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errc <- fmt.Errorf("serving %s: %w", s.srv.Addr, err)
+			}
+		}(s, listeners[i])
+	}
+	if started != nil {
+		started()
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		m.logger.Info(ctx, "shutdown requested", "cause", context.Cause(ctx))
+	case runErr = <-errc:
+		m.logger.Info(ctx, "server failed, shutting down", "err", runErr)
+	}
+
+	// ctx is done by now, so shutting down must not depend on it
+	shutdownCtx := context.WithoutCancel(ctx)
+	for _, f := range m.stopping {
+		f()
+	}
+	if runErr == nil && m.config.PreStopDelay > 0 {
+		m.logger.Info(shutdownCtx, "waiting before stopping servers", "delay", m.config.PreStopDelay)
+		time.Sleep(m.config.PreStopDelay)
+	}
+
+	errs := []error{runErr, m.drain(shutdownCtx)}
+	for _, h := range m.hooks {
+		errs = append(errs, m.runHook(shutdownCtx, h))
+	}
+	return errors.Join(errs...)
+}
+
+// listen listens on addr as http.Server.ListenAndServe would
+func listen(addr string) (net.Listener, error) {
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// drain shuts every server down together, so that they share the deadline
+func (m *Manager) drain(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.DrainTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for _, s := range m.servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				// Whatever did not drain in time is cut off
+				_ = srv.Close()
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("shutting down %s: %w", srv.Addr, err))
+				mu.Unlock()
+				return
+			}
+			m.logger.Info(ctx, "http server shutdown", "addr", srv.Addr)
+		}(s.srv)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (m *Manager) runHook(ctx context.Context, h hook) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.HookTimeout)
+	defer cancel()
+	if err := h.f(ctx); err != nil {
+		return fmt.Errorf("%s: %w", h.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Package limits bounds what a single request may cost the server: how large its body may be, and how long its
+// handler may run.
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/problem"
+)
+
+// NewBodyHandler rejects requests whose body is larger than maxBytes with 413 Request Entity Too Large. Requests that
+// announce their length are rejected up front; for the others, reading past the limit fails with an
+// *http.MaxBytesError, which handlers should answer with 413 as well.
+func NewBodyHandler(maxBytes int64, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			problem.Error(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body is larger than %d bytes", maxBytes))
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// A TimeoutHandler bounds the time Handler may take to respond. Unlike http.TimeoutHandler it does not buffer the
+// response, so that streamed responses are passed on as they are written; instead it relies on Handler giving up
+// once its request's context is done.
+//
+// When the deadline passes before Handler has started its response, whatever Handler writes is discarded and the
+// client gets Status with a problem body. A response that is already under way is cut short.
+type TimeoutHandler struct {
+	Timeout time.Duration
+	// Status is the status of a timed out request; zero means 503 Service Unavailable. Handlers that wait on another
+	// service should use 504 Gateway Timeout.
+	Status int
+	// OnTimeout, if set, is called for every request that timed out
+	OnTimeout func(r *http.Request)
+	Handler   http.Handler
+}
+
+func (h TimeoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.Timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{ResponseWriter: w, r: r, status: h.status(), timeout: h.Timeout, header: w.Header().Clone()}
+	h.Handler.ServeHTTP(tw, r)
+
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return
+	}
+	tw.mu.Lock()
+	if !tw.wroteHeader {
+		tw.writeTimeout()
+	}
+	tw.mu.Unlock()
+	if h.OnTimeout != nil {
+		h.OnTimeout(r)
+	}
+}
+
+func (h TimeoutHandler) status() int {
+	if h.Status == 0 {
+		return http.StatusServiceUnavailable
+	}
+	return h.Status
+}
+
+// timeoutWriter replaces the response of a handler that runs out of time before responding. The handler usually
+// notices its context is done and answers with an error of its own, which would otherwise hide the timeout.
+type timeoutWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	status  int
+	timeout time.Duration
+	// header holds the headers set before the handler ran, e.g. by CORS or request ID middleware, which the timeout
+	// response keeps
+	header http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(http.StatusOK)
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	if errors.Is(tw.r.Context().Err(), context.DeadlineExceeded) {
+		tw.writeTimeout()
+		return
+	}
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) writeTimeout() {
+	tw.wroteHeader = true
+	tw.timedOut = true
+	// Drop whatever headers the handler had set for its own response. The map is shared with the middleware around
+	// this one, so it is restored rather than replaced.
+	header := tw.ResponseWriter.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range tw.header {
+		header[k] = v
+	}
+	problem.Error(tw.ResponseWriter, tw.r, tw.status, fmt.Sprintf("the request did not complete within %s", tw.timeout))
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter, e.g. to flush streamed responses
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
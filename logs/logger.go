@@ -3,18 +3,36 @@ package logs
 import (
 	"context"
 	"fmt"
+	"log/slog"
+
 	"github.com/StephenGriese/stdlibapp/dictionary"
+	"github.com/StephenGriese/stdlibapp/requestid"
 )
 
 func NewLogger() dictionary.Logger {
-	return logger{}
+	return NewLeveledLogger(slog.LevelInfo)
+}
+
+// NewLeveledLogger returns a logger that drops messages below level. Pass a *slog.LevelVar to change the level while
+// running.
+func NewLeveledLogger(level slog.Leveler) dictionary.Logger {
+	return logger{level: level}
 }
 
-type logger struct{}
+type logger struct {
+	level slog.Leveler
+}
 
 var _ dictionary.Logger = logger{}
 
-func (l logger) Info(_ context.Context, msg string, keyvals ...any) {
+// Info prints msg and keyvals. The ID of the request being served, if any, is added as the request_id field.
+func (l logger) Info(ctx context.Context, msg string, keyvals ...any) {
+	if slog.LevelInfo < l.level.Level() {
+		return
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		keyvals = append([]any{"request_id", id}, keyvals...)
+	}
 	txt := []interface{}{msg}
 	txt = append(txt, keyvals)
 	fmt.Println(txt)
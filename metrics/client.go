@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+)
+
+const statusClassField = "status_class"
+
+// StatusClassError is the status class recorded when a request failed without a response
+const StatusClassError = "error"
+
+// ClientStatistics are meant to be used as client middle-ware to measure calls to a dependency, broken out by
+// operation and by the class of the response status (2xx, 4xx, ...).
+type ClientStatistics interface {
+	Update(operation string, statusCode int, begin time.Time, err error)
+}
+
+type clientStats struct {
+	requestCount   kitmetrics.Counter
+	errorCount     kitmetrics.Counter
+	requestLatency kitmetrics.Histogram
+}
+
+// NewClientStatistics creates and registers all of the metrics associated with a ClientStatistics
+func (f Factory) NewClientStatistics(subsystem string) ClientStatistics {
+	labels := []string{methodField, statusClassField}
+	return &clientStats{
+		requestCount:   f.NewCounter(subsystem, "request_count", "Number of requests sent", labels),
+		errorCount:     f.NewCounter(subsystem, "error_count", "Number of requests that failed without a response", []string{methodField}),
+		requestLatency: f.NewSummary(subsystem, "request_latency_milliseconds", "Total duration of requests in milliseconds", labels),
+	}
+}
+
+func (s *clientStats) Update(operation string, statusCode int, begin time.Time, err error) {
+	class := StatusClass(statusCode)
+	if err != nil {
+		class = StatusClassError
+		s.errorCount.With(methodField, operation).Add(1)
+	}
+	s.requestCount.With(methodField, operation, statusClassField, class).Add(1)
+	s.requestLatency.With(methodField, operation, statusClassField, class).Observe(computeDuration(begin))
+}
+
+// StatusClass returns the class of an HTTP status code, e.g. "2xx" for 200
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"sync/atomic"
+
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+)
+
+// ConnPoolStatistics are used to track the connection pool of an HTTP client. Connections are "open" from the time
+// they are dialed until they are closed, and "active" while a request holds them; the rest are idle. TLS handshakes
+// are timed by RequestPhaseStatistics.
+type ConnPoolStatistics interface {
+	ConnOpened()
+	ConnClosed()
+	ConnAcquired()
+	ConnReleased()
+	Dialed(err error)
+}
+
+type connPoolStats struct {
+	open   atomic.Int64
+	active atomic.Int64
+
+	activeConns    kitmetrics.Gauge
+	idleConns      kitmetrics.Gauge
+	dialCount      kitmetrics.Counter
+	dialErrorCount kitmetrics.Counter
+}
+
+// NewConnPoolStatistics creates and registers all of the metrics associated with a ConnPoolStatistics
+func (f Factory) NewConnPoolStatistics(subsystem string) ConnPoolStatistics {
+	return &connPoolStats{
+		activeConns:    f.NewGauge(subsystem, "active_connections", "Number of connections currently in use by a request", nil),
+		idleConns:      f.NewGauge(subsystem, "idle_connections", "Number of open connections not in use by a request", nil),
+		dialCount:      f.NewCounter(subsystem, "dial_count", "Number of connection attempts", nil),
+		dialErrorCount: f.NewCounter(subsystem, "dial_error_count", "Number of failed connection attempts", nil),
+	}
+}
+
+func (s *connPoolStats) ConnOpened() {
+	s.open.Add(1)
+	s.publish()
+}
+
+func (s *connPoolStats) ConnClosed() {
+	s.open.Add(-1)
+	s.publish()
+}
+
+func (s *connPoolStats) ConnAcquired() {
+	s.active.Add(1)
+	s.publish()
+}
+
+func (s *connPoolStats) ConnReleased() {
+	s.active.Add(-1)
+	s.publish()
+}
+
+func (s *connPoolStats) Dialed(err error) {
+	s.dialCount.Add(1)
+	if err != nil {
+		s.dialErrorCount.Add(1)
+	}
+}
+
+func (s *connPoolStats) publish() {
+	active := s.active.Load()
+	idle := s.open.Load() - active
+	// HTTP/2 multiplexes many requests over one connection, so active can exceed open
+	if idle < 0 {
+		idle = 0
+	}
+	s.activeConns.Set(float64(active))
+	s.idleConns.Set(float64(idle))
+}
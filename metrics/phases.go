@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+)
+
+// The phases of an outbound HTTP request that are tracked by RequestPhaseStatistics
+const (
+	PhaseDNSLookup    = "dns_lookup"
+	PhaseConnect      = "connect"
+	PhaseTLSHandshake = "tls_handshake"
+	PhaseFirstByte    = "time_to_first_byte"
+	PhaseBodyTransfer = "body_transfer"
+)
+
+// RequestPhaseStatistics break the latency of outbound HTTP requests down by phase, so that time spent on the network
+// can be told apart from time spent in the remote service.
+type RequestPhaseStatistics interface {
+	Observe(methodName, phase string, d time.Duration)
+}
+
+type requestPhaseStats struct {
+	histograms map[string]kitmetrics.Histogram
+}
+
+// NewRequestPhaseStatistics creates and registers one histogram per phase, each labeled by method
+func (f Factory) NewRequestPhaseStatistics(subsystem string) RequestPhaseStatistics {
+	buckets := []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000}
+	histograms := make(map[string]kitmetrics.Histogram)
+	for phase, help := range map[string]string{
+		PhaseDNSLookup:    "Duration of DNS lookups in milliseconds",
+		PhaseConnect:      "Duration of TCP connects in milliseconds",
+		PhaseTLSHandshake: "Duration of TLS handshakes in milliseconds",
+		PhaseFirstByte:    "Time from writing the request to the first response byte in milliseconds",
+		PhaseBodyTransfer: "Time from the first response byte to the end of the body in milliseconds",
+	} {
+		histograms[phase] = f.NewHistogram(subsystem, phase+"_milliseconds", help, buckets, fieldKeys)
+	}
+	return &requestPhaseStats{histograms: histograms}
+}
+
+func (s *requestPhaseStats) Observe(methodName, phase string, d time.Duration) {
+	h, ok := s.histograms[phase]
+	if !ok {
+		return
+	}
+	ms := float64(d.Nanoseconds()) / float64(time.Millisecond)
+	if ms < 0 {
+		ms = 0
+	}
+	h.With(methodField, methodName).Observe(ms)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+)
+
+const (
+	scopeField  = "scope"
+	resultField = "result"
+)
+
+// The results of a throttled call recorded by RateLimitStatistics
+const (
+	ThrottleDelayed  = "delayed"
+	ThrottleRejected = "rejected"
+)
+
+// RateLimitStatistics track calls held back by a client-side rate limiter. The scope tells which limit did the
+// throttling, e.g. "global" or "caller"; it must not be the key itself so that cardinality stays bounded.
+type RateLimitStatistics interface {
+	Throttled(scope, result string, wait time.Duration)
+}
+
+type rateLimitStats struct {
+	throttledCount kitmetrics.Counter
+	waitTime       kitmetrics.Histogram
+}
+
+// NewRateLimitStatistics creates and registers all of the metrics associated with a RateLimitStatistics
+func (f Factory) NewRateLimitStatistics(subsystem string) RateLimitStatistics {
+	buckets := []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000, 30000}
+	return &rateLimitStats{
+		throttledCount: f.NewCounter(subsystem, "throttled_count", "Number of calls delayed or rejected by the rate limiter", []string{scopeField, resultField}),
+		waitTime:       f.NewHistogram(subsystem, "throttle_wait_milliseconds", "Time calls spent waiting on the rate limiter in milliseconds", buckets, []string{scopeField}),
+	}
+}
+
+func (s *rateLimitStats) Throttled(scope, result string, wait time.Duration) {
+	s.throttledCount.With(scopeField, scope, resultField, result).Add(1)
+	if result == ThrottleDelayed {
+		s.waitTime.With(scopeField, scope).Observe(float64(wait.Nanoseconds()) / float64(time.Millisecond))
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+)
+
+// The labels of the request latency histogram
+const (
+	LabelEndpoint = "endpoint"
+	LabelStatus   = "status"
+)
+
+// Metrics observes the latency of every request in histogram, labeled by endpoint, the Route, and status
+func Metrics(histogram kitmetrics.Histogram) Middleware {
+	return func(next http.Handler) http.Handler {
+		return metricHandler{
+			histogram: histogram,
+			handler:   next,
+		}
+	}
+}
+
+type metricHandler struct {
+	handler   http.Handler
+	histogram kitmetrics.Histogram
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (mh metricHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	sw := newStatusCapturingResponseWriter(rw)
+
+	defer func(start time.Time) {
+		status := sw.status
+		// Recovery runs outside this middleware, so a panic is recorded as the 500 it is about to become, and passed on
+		v := recover()
+		if v != nil && v != http.ErrAbortHandler {
+			status = http.StatusInternalServerError
+		}
+		mh.histogram.With(LabelEndpoint, Route(r), LabelStatus, strconv.Itoa(status)).Observe(float64(time.Since(start).Milliseconds()))
+		if v != nil {
+			panic(v)
+		}
+	}(time.Now())
+
+	mh.handler.ServeHTTP(sw, r)
+}
+
+// statusWriter implements http.ResponseWriter to capture the http response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func newStatusCapturingResponseWriter(rw http.ResponseWriter) *statusWriter {
+	return &statusWriter{ResponseWriter: rw}
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter so that http.ResponseController can reach optional interfaces
+// such as http.Flusher.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
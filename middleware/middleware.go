@@ -0,0 +1,102 @@
+// Package middleware composes the middleware of the service's routes. A Router wraps its whole ServeMux in a global
+// Chain, so that every request, including one that matches no route, gets recovery, request IDs, tracing and
+// metrics, and then each route in the middleware particular to it.
+//
+// The standard order, from the outside in, is
+//
+//	recover → request ID → tracing → metrics → auth → rate limit → handler
+//
+// The first four belong in the global Chain; auth and rate limiting are passed per route.
+//
+// Routes are identified in logs, metrics and spans by the ServeMux pattern they matched, e.g. "GET /lookup/{word}",
+// which keeps label cardinality bounded however many paths the pattern matches. See Route.
+package middleware
+
+import (
+	"net/http"
+)
+
+// Unmatched is the route of requests that match no pattern
+const Unmatched = "unmatched"
+
+// A Middleware wraps a handler
+type Middleware func(next http.Handler) http.Handler
+
+// A Chain is a stack of middleware; the first one is the outermost
+type Chain []Middleware
+
+// NewChain returns a Chain of middleware. Nil middleware are skipped, which lets optional middleware be left out
+// without a condition at every route.
+func NewChain(middleware ...Middleware) Chain {
+	return Chain(nil).Append(middleware...)
+}
+
+// Append returns a new Chain with middleware added after, so inside, those of c
+func (c Chain) Append(middleware ...Middleware) Chain {
+	chain := make(Chain, 0, len(c)+len(middleware))
+	chain = append(chain, c...)
+	for _, m := range middleware {
+		if m != nil {
+			chain = append(chain, m)
+		}
+	}
+	return chain
+}
+
+// Then wraps handler in the middleware of c
+func (c Chain) Then(handler http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		handler = c[i](handler)
+	}
+	return handler
+}
+
+// Route returns the pattern r matched, or Unmatched. It is known to all middleware of a Router, not only to those
+// running after the ServeMux.
+func Route(r *http.Request) string {
+	if r.Pattern == "" {
+		return Unmatched
+	}
+	return r.Pattern
+}
+
+// A Router registers routes on a ServeMux, each wrapped in its own middleware, and serves the ServeMux wrapped in
+// the global Chain
+type Router struct {
+	mux     *http.ServeMux
+	handler http.Handler
+}
+
+// NewRouter returns a Router that registers routes on mux
+func NewRouter(mux *http.ServeMux, global Chain) *Router {
+	return &Router{mux: mux, handler: global.Then(mux)}
+}
+
+// Handle registers handler for pattern, wrapped in perRoute in order
+func (rt *Router) Handle(pattern string, handler http.Handler, perRoute ...Middleware) {
+	rt.mux.Handle(pattern, NewChain(perRoute...).Then(handler))
+}
+
+// HandleFunc is Handle for a handler function
+func (rt *Router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request), perRoute ...Middleware) {
+	rt.Handle(pattern, http.HandlerFunc(handler), perRoute...)
+}
+
+// ServeHTTP looks up the pattern the request matches before passing it to the global middleware, which would
+// otherwise only learn it once the ServeMux has run.
+//
+// A CORS preflight gets the pattern of the route it asks about, so that global middleware can answer it even though
+// no route is registered for OPTIONS; the ServeMux alone would answer 405 Method Not Allowed.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lookup := r
+	if method := r.Header.Get("Access-Control-Request-Method"); r.Method == http.MethodOptions && method != "" {
+		lookup = r.Clone(r.Context())
+		lookup.Method = method
+	}
+	if _, pattern := rt.mux.Handler(lookup); pattern != "" {
+		// Shallow copy, so that the request we were given is left alone
+		r = r.WithContext(r.Context())
+		r.Pattern = pattern
+	}
+	rt.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/StephenGriese/stdlibapp/recovery"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span named after the Route for every request, continuing the caller's trace when the
+// request carries one
+func Tracing(tracer trace.Tracer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := Route(r)
+			attrs := []attribute.KeyValue{
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			}
+			if r.Pattern != "" {
+				// http.route is the path template, without the method of the pattern
+				_, path, _ := strings.Cut(r.Pattern, " ")
+				if path == "" {
+					path = r.Pattern
+				}
+				attrs = append(attrs, attribute.String("http.route", path))
+			}
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+			defer span.End()
+			// The span has ended by the time an outer recovery middleware sees the panic, so it is recorded here
+			defer recovery.RepanicInSpan(span)
+
+			sw := newStatusCapturingResponseWriter(w)
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Package problem writes error responses as RFC 9457 problem details, so that clients get a machine-readable body
+// rather than a line of text.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of a problem details body
+const ContentType = "application/problem+json"
+
+// Details is the body of a problem response
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// New returns the Details of a problem that has no more specific type than its status
+func New(status int, detail string) Details {
+	return Details{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// Write writes d as the response
+func Write(w http.ResponseWriter, d Details) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(d.Status)
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+// Error replies with a problem that has no more specific type than its status, in the manner of http.Error
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	d := New(status, detail)
+	d.Instance = r.URL.Path
+	Write(w, d)
+}
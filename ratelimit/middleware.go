@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StephenGriese/stdlibapp/auth"
+	"github.com/StephenGriese/stdlibapp/problem"
+)
+
+// A KeyFunc returns the key that identifies the client of a request, or "" when it cannot tell
+type KeyFunc func(r *http.Request) string
+
+// The key sources understood by KeyFromSources
+const (
+	KeySourceJWT    = "jwt"
+	KeySourceHeader = "header"
+	KeySourceAPIKey = "apikey"
+	KeySourceIP     = "ip"
+)
+
+// KeyFromClaims returns the client ID of the verified token claims put into the context by auth.NewHandler
+func KeyFromClaims(r *http.Request) string {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.ClientID != "" {
+		return "jwt:" + claims.ClientID
+	}
+	return ""
+}
+
+// KeyFromHeader returns the value of the given request header
+func KeyFromHeader(header string) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(header); v != "" {
+			return "header:" + v
+		}
+		return ""
+	}
+}
+
+// KeyFromAPIKey returns a digest of the API key in the given request header, so that keys are not held in memory.
+// Only keys that auth.NewHandler has verified count; anyone can make up a new key for every request.
+func KeyFromAPIKey(header string) KeyFunc {
+	return func(r *http.Request) string {
+		if _, ok := auth.ClaimsFromContext(r.Context()); !ok {
+			return ""
+		}
+		if v := r.Header.Get(header); v != "" {
+			sum := sha256.Sum256([]byte(v))
+			return "apikey:" + hex.EncodeToString(sum[:16])
+		}
+		return ""
+	}
+}
+
+// KeyFromRemoteIP returns the IP address of the peer that sent the request
+func KeyFromRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// FirstKey returns the first non-empty key of keyFuncs
+func FirstKey(keyFuncs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, keyFunc := range keyFuncs {
+			if key := keyFunc(r); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+// KeyFromSources builds a KeyFunc that tries the named sources in order: "jwt" (the verified client ID), "header"
+// (X-Client-Id), "apikey" (X-API-Key) or "ip". The client picks its own X-Client-Id, so a client that sends a new one
+// with every request is never limited; only use "header" when a proxy in front of the server sets it.
+func KeyFromSources(sources []string) (KeyFunc, error) {
+	keyFuncs := make([]KeyFunc, 0, len(sources))
+	for _, source := range sources {
+		switch strings.TrimSpace(source) {
+		case KeySourceJWT:
+			keyFuncs = append(keyFuncs, KeyFromClaims)
+		case KeySourceHeader:
+			keyFuncs = append(keyFuncs, KeyFromHeader("X-Client-Id"))
+		case KeySourceAPIKey:
+			keyFuncs = append(keyFuncs, KeyFromAPIKey("X-API-Key"))
+		case KeySourceIP:
+			keyFuncs = append(keyFuncs, KeyFromRemoteIP)
+		default:
+			return nil, fmt.Errorf("ratelimit: unknown key source %q", source)
+		}
+	}
+	return FirstKey(keyFuncs...), nil
+}
+
+// A RejectFunc is told about every rejected request, e.g. to count it
+type RejectFunc func(r *http.Request, key string)
+
+// NewHandler returns middleware that limits requests to handler by the key returned from keyFunc. Requests without a
+// key are let through. Every response carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers;
+// rejected requests get 429 Too Many Requests with Retry-After.
+func NewHandler(limiter Limiter, keyFunc KeyFunc, onReject RejectFunc, handler http.Handler) http.Handler {
+	return rateLimitHandler{limiter: limiter, keyFunc: keyFunc, onReject: onReject, handler: handler}
+}
+
+type rateLimitHandler struct {
+	limiter  Limiter
+	keyFunc  KeyFunc
+	onReject RejectFunc
+	handler  http.Handler
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h rateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.keyFunc(r)
+	if key == "" {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	d := h.limiter.Allow(key, time.Now())
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	w.Header().Set("RateLimit-Reset", seconds(d.Reset))
+	if d.Allowed {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if h.onReject != nil {
+		h.onReject(r, key)
+	}
+	w.Header().Set("Retry-After", seconds(d.RetryAfter))
+	problem.Error(w, r, http.StatusTooManyRequests, fmt.Sprintf("the rate limit is used up; retry after %s seconds", seconds(d.RetryAfter)))
+}
+
+// seconds formats d as whole seconds, rounding up so that clients never retry too early
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
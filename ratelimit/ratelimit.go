@@ -0,0 +1,268 @@
+// Package ratelimit limits the rate of inbound requests per client. Limiters track one bucket or window per key,
+// and the HTTP middleware answers requests over the limit with 429 Too Many Requests.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxKeys bounds the number of keys tracked before stale ones are swept
+const maxKeys = 10000
+
+// A Decision is the outcome of asking a Limiter whether a request may proceed
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests allowed per window
+	Limit int
+	// Remaining is the number of requests left in the current window
+	Remaining int
+	// Reset is the time until the quota is fully restored
+	Reset time.Duration
+	// RetryAfter is the time until the next request would be allowed; it is zero when Allowed
+	RetryAfter time.Duration
+}
+
+// A Limiter decides whether a request identified by key may proceed
+type Limiter interface {
+	Allow(key string, now time.Time) Decision
+}
+
+// The algorithms supported by New
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmSlidingWindow = "sliding_window"
+)
+
+// New returns a Limiter allowing limit requests per window using the named algorithm. For the token bucket, burst is
+// the bucket size; a zero burst means limit.
+func New(algorithm string, limit int, window time.Duration, burst int) (Limiter, error) {
+	if limit <= 0 || window <= 0 {
+		return nil, fmt.Errorf("ratelimit: limit and window must be positive, got %d per %s", limit, window)
+	}
+	switch algorithm {
+	case AlgorithmTokenBucket:
+		return NewTokenBucket(limit, window, burst), nil
+	case AlgorithmSlidingWindow:
+		return NewSlidingWindow(limit, window), nil
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %q", algorithm)
+	}
+}
+
+// Update returns a Limiter with the given settings, as New does. A Limiter l of the same algorithm is reconfigured in
+// place, so that every key keeps the share of its quota it has used; otherwise, e.g. after switching algorithms, every
+// key starts over with a full quota.
+func Update(l Limiter, algorithm string, limit int, window time.Duration, burst int) (Limiter, error) {
+	if limit <= 0 || window <= 0 {
+		return nil, fmt.Errorf("ratelimit: limit and window must be positive, got %d per %s", limit, window)
+	}
+	now := time.Now()
+	switch l := l.(type) {
+	case *TokenBucket:
+		if algorithm == AlgorithmTokenBucket {
+			l.Reconfigure(limit, window, burst, now)
+			return l, nil
+		}
+	case *SlidingWindow:
+		if algorithm == AlgorithmSlidingWindow {
+			l.Reconfigure(limit, window, now)
+			return l, nil
+		}
+	}
+	return New(algorithm, limit, window, burst)
+}
+
+// TokenBucket is a Limiter that refills limit tokens per window into a bucket of size burst
+type TokenBucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a new TokenBucket
+func NewTokenBucket(limit int, window time.Duration, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = limit
+	}
+	return &TokenBucket{
+		rate:    float64(limit) / window.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow implements Limiter
+func (tb *TokenBucket) Allow(key string, now time.Time) Decision {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	b, ok := tb.buckets[key]
+	if !ok {
+		if len(tb.buckets) >= maxKeys {
+			tb.sweep(now)
+		}
+		b = &bucket{tokens: tb.burst, last: now}
+		tb.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.tokens+elapsed*tb.rate, tb.burst)
+		b.last = now
+	}
+
+	d := Decision{Limit: int(tb.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = tb.secondsToDuration((1 - b.tokens) / tb.rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = tb.secondsToDuration((tb.burst - b.tokens) / tb.rate)
+	return d
+}
+
+// Reconfigure changes the limit, window and burst. Each bucket keeps the share of the old burst it has left, scaled to
+// the new one.
+func (tb *TokenBucket) Reconfigure(limit int, window time.Duration, burst int, now time.Time) {
+	if burst <= 0 {
+		burst = limit
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, b := range tb.buckets {
+		if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+			b.tokens = math.Min(b.tokens+elapsed*tb.rate, tb.burst)
+			b.last = now
+		}
+		b.tokens = b.tokens / tb.burst * float64(burst)
+	}
+	tb.rate = float64(limit) / window.Seconds()
+	tb.burst = float64(burst)
+}
+
+// sweep drops buckets that have refilled completely; recreating them later yields the same state
+func (tb *TokenBucket) sweep(now time.Time) {
+	for key, b := range tb.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*tb.rate >= tb.burst {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+func (tb *TokenBucket) secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// SlidingWindow is a Limiter that allows limit requests in any window-long period. It approximates the sliding
+// window by weighting the count of the previous fixed window by how much of it still overlaps.
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*slidingWindow
+}
+
+type slidingWindow struct {
+	start    time.Time
+	current  int
+	previous int
+}
+
+// NewSlidingWindow returns a new SlidingWindow
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*slidingWindow),
+	}
+}
+
+// Allow implements Limiter
+func (sw *SlidingWindow) Allow(key string, now time.Time) Decision {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	w, ok := sw.windows[key]
+	if !ok {
+		if len(sw.windows) >= maxKeys {
+			sw.sweep(now)
+		}
+		w = &slidingWindow{start: now.Truncate(sw.window)}
+		sw.windows[key] = w
+	}
+	if elapsed := now.Sub(w.start); elapsed >= sw.window {
+		if elapsed < 2*sw.window {
+			w.previous = w.current
+		} else {
+			w.previous = 0
+		}
+		w.current = 0
+		w.start = now.Truncate(sw.window)
+	}
+
+	elapsed := now.Sub(w.start)
+	overlap := 1 - float64(elapsed)/float64(sw.window)
+	count := float64(w.previous)*overlap + float64(w.current)
+
+	d := Decision{Limit: sw.limit, Reset: sw.window - elapsed}
+	if count+1 <= float64(sw.limit) {
+		w.current++
+		count++
+		d.Allowed = true
+	} else if w.previous > 0 && float64(w.current) < float64(sw.limit) {
+		// Wait until enough of the previous window has slid out
+		needed := (count + 1 - float64(sw.limit)) / float64(w.previous)
+		d.RetryAfter = time.Duration(needed * float64(sw.window))
+	} else {
+		d.RetryAfter = d.Reset
+	}
+	d.Remaining = max(sw.limit-int(math.Ceil(count)), 0)
+	return d
+}
+
+// Reconfigure changes the limit and window. The counts of each key are scaled to the new limit, so that it keeps the
+// share of its quota it has used. A new window cannot be lined up with the old one, so the count over the old window
+// is carried into the current new one.
+func (sw *SlidingWindow) Reconfigure(limit int, window time.Duration, now time.Time) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	scale := float64(limit) / float64(sw.limit)
+	for _, w := range sw.windows {
+		if window == sw.window {
+			w.current = int(math.Round(float64(w.current) * scale))
+			w.previous = int(math.Round(float64(w.previous) * scale))
+			continue
+		}
+		count := 0.0
+		if elapsed := now.Sub(w.start); elapsed < sw.window {
+			count = float64(w.previous)*(1-float64(elapsed)/float64(sw.window)) + float64(w.current)
+		} else if elapsed < 2*sw.window {
+			count = float64(w.current) * (1 - float64(elapsed-sw.window)/float64(sw.window))
+		}
+		w.start = now.Truncate(window)
+		w.current = int(math.Round(count * scale))
+		w.previous = 0
+	}
+	sw.limit = limit
+	sw.window = window
+}
+
+// sweep drops windows that no longer count towards any limit
+func (sw *SlidingWindow) sweep(now time.Time) {
+	for key, w := range sw.windows {
+		if now.Sub(w.start) >= 2*sw.window {
+			delete(sw.windows, key)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package recovery turns a panicking handler into a 500 response. Without it net/http logs the panic to stderr and
+// drops the connection, leaving no metric, no structured log and no trace of what happened.
+package recovery
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/StephenGriese/stdlibapp/dictionary"
+	"github.com/StephenGriese/stdlibapp/kitmetrics"
+	"github.com/StephenGriese/stdlibapp/problem"
+	"github.com/StephenGriese/stdlibapp/requestid"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewHandler recovers panics in handler. Each panic is logged with its stack, recorded as an exception on the
+// request's span and counted by panics, which may be nil. The client gets a 500 problem unless the response had
+// already started, in which case the connection is closed.
+//
+// http.ErrAbortHandler is passed on: it is how handlers abort a response on purpose.
+func NewHandler(logger dictionary.Logger, panics kitmetrics.Counter, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			stack := debug.Stack()
+
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+			if p, ok := v.(tracedPanic); ok {
+				// Already recorded on the span it happened in
+				v, span = p.value, p.span
+			} else {
+				recordPanic(span, v)
+			}
+
+			// The logger adds the request ID from the context. When the request ID middleware runs inside this one,
+			// the ID is not in the context yet, but it has already been set on the response.
+			if requestid.FromContext(ctx) == "" {
+				ctx = requestid.WithRequestID(ctx, w.Header().Get(requestid.Header))
+			}
+			logger.Info(ctx, "panic serving request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"trace_id", traceID(span),
+				"panic", v,
+				"stack", string(stack))
+			if panics != nil {
+				panics.Add(1)
+			}
+
+			if rw.wroteHeader {
+				// Too late for a 500; make sure the client does not take the partial response for a whole one
+				panic(http.ErrAbortHandler)
+			}
+			problem.Error(w, r, http.StatusInternalServerError, "")
+		}()
+		handler.ServeHTTP(rw, r)
+	})
+}
+
+// tracedPanic carries a panic recorded by RepanicInSpan on to NewHandler
+type tracedPanic struct {
+	value any
+	span  trace.Span
+}
+
+// RepanicInSpan must be deferred by middleware that starts a span between NewHandler and the handlers, after deferring
+// span.End. The span would otherwise have ended by the time NewHandler sees a panic. It records the panic on span and
+// panics again, so that NewHandler logs it with the span's trace ID.
+func RepanicInSpan(span trace.Span) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	recordPanic(span, v)
+	panic(tracedPanic{value: v, span: span})
+}
+
+// recordPanic marks span as failed, with an exception event holding the panic and its stack
+func recordPanic(span trace.Span, v any) {
+	err := fmt.Errorf("panic: %v", v)
+	span.RecordError(err, trace.WithStackTrace(true))
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func traceID(span trace.Span) string {
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// responseWriter records whether the response has started
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
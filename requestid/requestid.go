@@ -0,0 +1,82 @@
+// Package requestid gives every request an ID that is logged with everything done on its behalf, echoed to the
+// caller and passed on to downstream services, so that a caller's complaint can be found in our logs and theirs.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// Header carries the request ID, both in requests and in responses
+const Header = "X-Request-ID"
+
+// maxLength bounds the IDs accepted from callers, which end up in every log line of the request
+const maxLength = 128
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+)
+
+// WithRequestID returns a copy of ctx that carries id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// FromContext returns the request ID stored by WithRequestID, or "" if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// New returns a UUIDv7. Its leading timestamp makes IDs sort by creation time, which helps when searching logs.
+func New() string {
+	var u [16]byte
+	binary.BigEndian.PutUint64(u[0:8], uint64(time.Now().UnixMilli())<<16)
+	_, _ = rand.Read(u[6:])
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// NewHandler puts the request ID into the request context and the response headers. The caller's X-Request-ID is
+// used when it is acceptable; otherwise a new ID is generated.
+func NewHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		handler.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// valid accepts IDs of printable ASCII, so that callers cannot inject anything into logs or headers
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	clientCertKey contextKey = iota
+)
+
+// ClientCertificate returns the verified client certificate put into the context by WithClientCertificate
+func ClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(clientCertKey).(*x509.Certificate)
+	return cert, ok
+}
+
+// ClientSubject returns the subject of the verified client certificate, e.g. "CN=batch,O=Example", or "" when the
+// client did not present one
+func ClientSubject(ctx context.Context) string {
+	if cert, ok := ClientCertificate(ctx); ok {
+		return cert.Subject.String()
+	}
+	return ""
+}
+
+// WithClientCertificate puts the client certificate verified during the TLS handshake into the request context, so
+// that handlers can make authorization decisions on it
+func WithClientCertificate(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// VerifiedChains is only set when the certificate was verified against the client CAs
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), clientCertKey, r.TLS.VerifiedChains[0][0]))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
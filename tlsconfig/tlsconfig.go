@@ -0,0 +1,196 @@
+// Package tlsconfig builds the TLS configuration of the server: certificates that are reloaded when their files
+// change, minimum version and cipher suite settings, and optional verification of client certificates (mTLS).
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The client authentication modes understood by New
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+// Config holds the TLS settings of the server
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is "1.2" or "1.3"
+	MinVersion string
+	// CipherSuites are names as returned by tls.CipherSuiteName; empty means Go's defaults. They do not apply to
+	// TLS 1.3, whose suites are not configurable.
+	CipherSuites []string
+	// ClientCAFile is the CA bundle client certificates are verified against
+	ClientCAFile string
+	// ClientAuth is one of "none", "request" (verify a certificate if one is sent) or "require"
+	ClientAuth string
+}
+
+// Enabled reports whether TLS is configured
+func (c Config) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// New returns a tls.Config for serving, and the CertReloader behind its certificate
+func New(c Config) (*tls.Config, *CertReloader, error) {
+	reloader, err := NewCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	switch c.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return nil, nil, fmt.Errorf("tlsconfig: unsupported minimum version %q", c.MinVersion)
+	}
+
+	if len(c.CipherSuites) > 0 {
+		if cfg.CipherSuites, err = cipherSuites(c.CipherSuites); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := configureClientAuth(cfg, c); err != nil {
+		return nil, nil, err
+	}
+	return cfg, reloader, nil
+}
+
+func cipherSuites(names []string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("tlsconfig: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func configureClientAuth(cfg *tls.Config, c Config) error {
+	switch c.ClientAuth {
+	case "", ClientAuthNone:
+		return nil
+	case ClientAuthRequest:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("tlsconfig: unknown client auth mode %q", c.ClientAuth)
+	}
+
+	if c.ClientCAFile == "" {
+		return errors.New("tlsconfig: a client CA bundle is required to verify client certificates")
+	}
+	pem, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("tlsconfig: no certificates found in %s", c.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	return nil
+}
+
+// A CertReloader serves a certificate and key pair from files, and reloads them when the files change so that
+// renewed certificates are picked up without a restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// NewCertReloader returns a CertReloader that has loaded the given files
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the certificate again if either file has changed since it was last loaded, and reports whether it
+// did. On error the current certificate is kept.
+func (r *CertReloader) Reload() (bool, error) {
+	modTimes, err := r.modTimesNow()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("tlsconfig: loading certificate: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch checks the files for changes every interval until ctx is done. Errors are passed to onError, if set.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (r *CertReloader) modTimesNow() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}